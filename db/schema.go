@@ -16,7 +16,12 @@ func InitializeSchema(db *sql.DB) {
 		age INTEGER NOT NULL,
 		gender TEXT NOT NULL,
 		email TEXT NOT NULL UNIQUE,
-		password_hash TEXT NOT NULL
+		password_hash TEXT NOT NULL,
+		email_verified_at DATETIME DEFAULT NULL,
+		totp_secret TEXT DEFAULT NULL,
+		totp_enabled_at DATETIME DEFAULT NULL,
+		totp_last_step INTEGER NOT NULL DEFAULT 0,
+		role TEXT NOT NULL DEFAULT 'user'
 	);`
 
 	// last_active is a new column for tracking online users
@@ -26,7 +31,12 @@ func InitializeSchema(db *sql.DB) {
 		user_id TEXT NOT NULL,
 		nickname TEXT NOT NULL,
 		expires_at DATETIME NOT NULL,
+		absolute_expires_at DATETIME NOT NULL,
+		remember INTEGER NOT NULL DEFAULT 0,
 		last_active DATETIME NOT NULL,
+		created_at DATETIME DEFAULT NULL,
+		user_agent TEXT NOT NULL DEFAULT '',
+		ip TEXT NOT NULL DEFAULT '',
 		FOREIGN KEY(user_id) REFERENCES users(id)
 	);`
 
@@ -40,6 +50,12 @@ func InitializeSchema(db *sql.DB) {
 		likes INTEGER DEFAULT 0, 
 		dislikes INTEGER DEFAULT 0, 
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		edited_at DATETIME DEFAULT NULL,
+		deleted_at DATETIME DEFAULT NULL,
+		pinned_at DATETIME DEFAULT NULL,
+		locked_at DATETIME DEFAULT NULL,
+		hidden_at DATETIME DEFAULT NULL,
+		hidden_reason TEXT DEFAULT NULL,
 		FOREIGN KEY(user_id) REFERENCES users(id)
 	);`
 
@@ -52,6 +68,8 @@ CREATE TABLE IF NOT EXISTS comments (
 	content TEXT NOT NULL,
 	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 	parent_id TEXT DEFAULT NULL,
+	edited_at DATETIME DEFAULT NULL,
+	deleted_at DATETIME DEFAULT NULL,
 	FOREIGN KEY(post_id) REFERENCES posts(id),
 	FOREIGN KEY(user_id) REFERENCES users(id),
 	FOREIGN KEY(parent_id) REFERENCES comments(id)
@@ -77,6 +95,139 @@ CREATE TABLE IF NOT EXISTS chats (
 	FOREIGN KEY(sender_id) REFERENCES users(id)
 );`
 
+	// One row per user and post; value is 1 for a like and -1 for a dislike
+	createPostVotesTable := `
+CREATE TABLE IF NOT EXISTS post_votes (
+	user_id TEXT NOT NULL,
+	post_id TEXT NOT NULL,
+	value INTEGER NOT NULL CHECK (value IN (1, -1)),
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, post_id),
+	FOREIGN KEY(user_id) REFERENCES users(id),
+	FOREIGN KEY(post_id) REFERENCES posts(id)
+);`
+
+	// Highest message ID each participant has read in a chat, used for unread counts
+	createChatReadsTable := `
+CREATE TABLE IF NOT EXISTS chat_reads (
+	chat_id INTEGER NOT NULL,
+	user_id TEXT NOT NULL,
+	last_read_message_id INTEGER NOT NULL DEFAULT 0,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (chat_id, user_id),
+	FOREIGN KEY(chat_id) REFERENCES chats(id),
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);`
+
+	// Post categories; posts.category_id holds the slug
+	createCategoriesTable := `
+CREATE TABLE IF NOT EXISTS categories (
+	slug TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	sort_order INTEGER NOT NULL DEFAULT 0,
+	archived INTEGER NOT NULL DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);`
+
+	// Password reset tokens; only the SHA-256 of each token is stored
+	createPasswordResetsTable := `
+CREATE TABLE IF NOT EXISTS password_resets (
+	token_hash TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	expires_at DATETIME NOT NULL,
+	used_at DATETIME DEFAULT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);`
+
+	// Outstanding email verification links; the nonce is embedded in the signed link
+	createEmailVerificationsTable := `
+CREATE TABLE IF NOT EXISTS email_verifications (
+	nonce TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	email TEXT NOT NULL,
+	expires_at DATETIME NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);`
+
+	// Hashed one-time recovery codes for accounts with two-factor authentication
+	createRecoveryCodesTable := `
+CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+	code_hash TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	used_at DATETIME DEFAULT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);`
+
+	// Logins that passed the password check and still owe a second factor
+	createPendingLoginsTable := `
+CREATE TABLE IF NOT EXISTS pending_logins (
+	token_hash TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	expires_at DATETIME NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	remember INTEGER NOT NULL DEFAULT 0,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);`
+
+	// Logins locked out after repeated failures; subject is a user ID, unknown login name or IP
+	createLoginLockoutsTable := `
+CREATE TABLE IF NOT EXISTS login_lockouts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	scope TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	failures INTEGER NOT NULL,
+	locked_until DATETIME NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);`
+
+	// Every moderator action, with who took it and when
+	createModerationLogTable := `
+CREATE TABLE IF NOT EXISTS moderation_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	moderator_id TEXT NOT NULL,
+	action TEXT NOT NULL,
+	target_type TEXT NOT NULL,
+	target_id TEXT NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(moderator_id) REFERENCES users(id)
+);`
+
+	// User reports against posts, comments, chat messages and users. status moves
+	// from open to claimed to resolved or dismissed; moderation_log_id links the
+	// log entry that closed the report.
+	createReportsTable := `
+CREATE TABLE IF NOT EXISTS reports (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	reporter_id TEXT NOT NULL,
+	target_type TEXT NOT NULL,
+	target_id TEXT NOT NULL,
+	excerpt TEXT NOT NULL DEFAULT '',
+	category TEXT NOT NULL,
+	details TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL DEFAULT 'open',
+	claimed_by TEXT DEFAULT NULL,
+	claimed_at DATETIME DEFAULT NULL,
+	resolved_by TEXT DEFAULT NULL,
+	resolved_at DATETIME DEFAULT NULL,
+	resolution_note TEXT NOT NULL DEFAULT '',
+	moderation_log_id INTEGER DEFAULT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(reporter_id) REFERENCES users(id),
+	FOREIGN KEY(claimed_by) REFERENCES users(id),
+	FOREIGN KEY(resolved_by) REFERENCES users(id),
+	FOREIGN KEY(moderation_log_id) REFERENCES moderation_log(id)
+);`
+
+	// A reporter can have only one pending report per target
+	createReportsPendingIndex := `
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reports_pending
+ON reports(reporter_id, target_type, target_id)
+WHERE status IN ('open', 'claimed');`
+
 	_, err := db.Exec(createUsersTable)
 	if err != nil {
 		log.Fatalf("error creating users table: %v", err)
@@ -107,11 +258,113 @@ CREATE TABLE IF NOT EXISTS chats (
 		log.Fatalf("error creating messages table: %v", err)
 	}
 
+	_, err = db.Exec(createPostVotesTable)
+	if err != nil {
+		log.Fatalf("error creating post_votes table: %v", err)
+	}
+
+	_, err = db.Exec(createChatReadsTable)
+	if err != nil {
+		log.Fatalf("error creating chat_reads table: %v", err)
+	}
+
+	_, err = db.Exec(createCategoriesTable)
+	if err != nil {
+		log.Fatalf("error creating categories table: %v", err)
+	}
+
+	_, err = db.Exec(createPasswordResetsTable)
+	if err != nil {
+		log.Fatalf("error creating password_resets table: %v", err)
+	}
+
+	_, err = db.Exec(createEmailVerificationsTable)
+	if err != nil {
+		log.Fatalf("error creating email_verifications table: %v", err)
+	}
+
+	_, err = db.Exec(createRecoveryCodesTable)
+	if err != nil {
+		log.Fatalf("error creating totp_recovery_codes table: %v", err)
+	}
+
+	_, err = db.Exec(createPendingLoginsTable)
+	if err != nil {
+		log.Fatalf("error creating pending_logins table: %v", err)
+	}
+
+	_, err = db.Exec(createLoginLockoutsTable)
+	if err != nil {
+		log.Fatalf("error creating login_lockouts table: %v", err)
+	}
+
+	_, err = db.Exec(createModerationLogTable)
+	if err != nil {
+		log.Fatalf("error creating moderation_log table: %v", err)
+	}
+
+	_, err = db.Exec(createReportsTable)
+	if err != nil {
+		log.Fatalf("error creating reports table: %v", err)
+	}
+
+	_, err = db.Exec(createReportsPendingIndex)
+	if err != nil {
+		log.Fatalf("error creating reports index: %v", err)
+	}
+
 	alterSessionsTable := `
 	ALTER TABLE sessions ADD COLUMN last_active DATETIME DEFAULT CURRENT_TIMESTAMP;`
 
 	db.Exec(alterSessionsTable) // Ignore error - column might already exist
 
+	// Absolute expiry; sessions from before it existed end at their current idle expiry
+	if _, err := db.Exec(`ALTER TABLE sessions ADD COLUMN absolute_expires_at DATETIME DEFAULT NULL;`); err == nil {
+		db.Exec(`UPDATE sessions SET absolute_expires_at = expires_at WHERE absolute_expires_at IS NULL;`)
+	}
+	db.Exec(`ALTER TABLE sessions ADD COLUMN remember INTEGER NOT NULL DEFAULT 0;`)       // Ignore error - column might already exist
+	db.Exec(`ALTER TABLE pending_logins ADD COLUMN remember INTEGER NOT NULL DEFAULT 0;`) // Ignore error - column might already exist
+
+	// Metadata shown when users review their sessions
+	if _, err := db.Exec(`ALTER TABLE sessions ADD COLUMN created_at DATETIME DEFAULT NULL;`); err == nil {
+		db.Exec(`UPDATE sessions SET created_at = last_active WHERE created_at IS NULL;`)
+	}
+	db.Exec(`ALTER TABLE sessions ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';`) // Ignore error - column might already exist
+	db.Exec(`ALTER TABLE sessions ADD COLUMN ip TEXT NOT NULL DEFAULT '';`)         // Ignore error - column might already exist
+
+	// Accounts that existed before email verification was introduced count as verified
+	if _, err := db.Exec(`ALTER TABLE users ADD COLUMN email_verified_at DATETIME DEFAULT NULL;`); err == nil {
+		db.Exec(`UPDATE users SET email_verified_at = CURRENT_TIMESTAMP WHERE email_verified_at IS NULL;`)
+	}
+
+	// Two-factor authentication; a secret without totp_enabled_at is an unconfirmed enrollment
+	db.Exec(`ALTER TABLE users ADD COLUMN totp_secret TEXT DEFAULT NULL;`)             // Ignore error - column might already exist
+	db.Exec(`ALTER TABLE users ADD COLUMN totp_enabled_at DATETIME DEFAULT NULL;`)     // Ignore error - column might already exist
+	db.Exec(`ALTER TABLE users ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0;`) // Ignore error - column might already exist
+
+	// Roles: user, moderator or admin
+	db.Exec(`ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user';`) // Ignore error - column might already exist
+
+	// Edit and soft-delete tracking for posts created before these columns existed
+	db.Exec(`ALTER TABLE posts ADD COLUMN edited_at DATETIME DEFAULT NULL;`)  // Ignore error - column might already exist
+	db.Exec(`ALTER TABLE posts ADD COLUMN deleted_at DATETIME DEFAULT NULL;`) // Ignore error - column might already exist
+
+	// Moderation state of posts
+	db.Exec(`ALTER TABLE posts ADD COLUMN pinned_at DATETIME DEFAULT NULL;`) // Ignore error - column might already exist
+	db.Exec(`ALTER TABLE posts ADD COLUMN locked_at DATETIME DEFAULT NULL;`) // Ignore error - column might already exist
+	db.Exec(`ALTER TABLE posts ADD COLUMN hidden_at DATETIME DEFAULT NULL;`) // Ignore error - column might already exist
+	db.Exec(`ALTER TABLE posts ADD COLUMN hidden_reason TEXT DEFAULT NULL;`) // Ignore error - column might already exist
+
+	// Same for comments; deleted comments with replies are kept as tombstones
+	db.Exec(`ALTER TABLE comments ADD COLUMN edited_at DATETIME DEFAULT NULL;`)  // Ignore error - column might already exist
+	db.Exec(`ALTER TABLE comments ADD COLUMN deleted_at DATETIME DEFAULT NULL;`) // Ignore error - column might already exist
+
+	// Per-post comment lookups back the comment counts in every post payload
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_comments_post_id ON comments(post_id);`)
+
+	migrateCategories(db)
+	initializeSearch(db)
+
 	// Update existing sessions that might not have last_active set
 	updateExistingSessions := `
 	UPDATE sessions 
@@ -125,3 +378,46 @@ CREATE TABLE IF NOT EXISTS chats (
 
 	log.Println("✅ Database schema initialized successfully.")
 }
+
+// defaultCategories are the categories offered before any were managed by an admin
+var defaultCategories = []struct {
+	Slug, Name string
+}{
+	{"general", "General"},
+	{"golang", "Golang"},
+	{"html", "HTML"},
+	{"javascript", "JavaScript"},
+	{"css", "CSS"},
+}
+
+// migrateCategories seeds the default categories into an empty table and
+// registers any free-text category already used by a post, so every
+// posts.category_id has a row. Defaults are only seeded once, so ones an admin
+// merged away stay gone.
+func migrateCategories(db *sql.DB) {
+	var existing int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM categories`).Scan(&existing); err != nil {
+		log.Printf("Warning: Could not count categories: %v", err)
+	}
+	if existing == 0 {
+		for i, c := range defaultCategories {
+			_, err := db.Exec(`INSERT OR IGNORE INTO categories (slug, name, sort_order) VALUES (?, ?, ?)`,
+				c.Slug, c.Name, i)
+			if err != nil {
+				log.Printf("Warning: Could not seed category %s: %v", c.Slug, err)
+			}
+		}
+	}
+
+	_, err := db.Exec(`UPDATE posts SET category_id = 'general' WHERE category_id IS NULL OR TRIM(category_id) = ''`)
+	if err != nil {
+		log.Printf("Warning: Could not default empty post categories: %v", err)
+	}
+
+	_, err = db.Exec(`
+	INSERT OR IGNORE INTO categories (slug, name, sort_order)
+	SELECT DISTINCT category_id, category_id, 1000 FROM posts`)
+	if err != nil {
+		log.Printf("Warning: Could not migrate post categories: %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+)
+
+// searchTriggers keep the FTS5 indexes in step with posts and comments. Soft
+// deleted rows stay indexed and are filtered out when searching.
+var searchTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS posts_fts_insert AFTER INSERT ON posts BEGIN
+		INSERT INTO posts_fts (post_id, title, content) VALUES (new.id, new.title, new.content);
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS posts_fts_delete AFTER DELETE ON posts BEGIN
+		DELETE FROM posts_fts WHERE post_id = old.id;
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS posts_fts_update AFTER UPDATE OF title, content ON posts BEGIN
+		DELETE FROM posts_fts WHERE post_id = old.id;
+		INSERT INTO posts_fts (post_id, title, content) VALUES (new.id, new.title, new.content);
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS comments_fts_insert AFTER INSERT ON comments BEGIN
+		INSERT INTO comments_fts (comment_id, content) VALUES (new.id, new.content);
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS comments_fts_delete AFTER DELETE ON comments BEGIN
+		DELETE FROM comments_fts WHERE comment_id = old.id;
+	END;`,
+	`CREATE TRIGGER IF NOT EXISTS comments_fts_update AFTER UPDATE OF content ON comments BEGIN
+		DELETE FROM comments_fts WHERE comment_id = old.id;
+		INSERT INTO comments_fts (comment_id, content) VALUES (new.id, new.content);
+	END;`,
+}
+
+// initializeSearch creates the FTS5 tables and triggers behind /api/search and
+// builds the index whenever its triggers were missing, so existing databases
+// become searchable. SQLite must be built with FTS5 (go build -tags
+// sqlite_fts5); without it search is left disabled and the rest of the forum
+// works as before.
+func initializeSearch(db *sql.DB) {
+	if !fts5Available(db) {
+		// A database indexed by an FTS5 build keeps triggers that would fail
+		// every post and comment insert, so drop them; the next FTS5 start
+		// recreates them and rebuilds the index
+		dropSearchTriggers(db)
+		log.Println("Warning: SQLite was built without FTS5, search is disabled (build with -tags sqlite_fts5)")
+		return
+	}
+
+	var indexed bool
+	err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'trigger' AND name = 'comments_fts_insert')`).Scan(&indexed)
+	if err != nil {
+		log.Printf("Warning: Could not check search index: %v", err)
+		return
+	}
+
+	_, err = db.Exec(`
+	CREATE VIRTUAL TABLE IF NOT EXISTS posts_fts USING fts5(
+		post_id UNINDEXED, title, content, tokenize = 'porter unicode61'
+	);`)
+	if err != nil {
+		log.Fatalf("error creating posts_fts table: %v", err)
+	}
+
+	_, err = db.Exec(`
+	CREATE VIRTUAL TABLE IF NOT EXISTS comments_fts USING fts5(
+		comment_id UNINDEXED, content, tokenize = 'porter unicode61'
+	);`)
+	if err != nil {
+		log.Fatalf("error creating comments_fts table: %v", err)
+	}
+
+	for _, trigger := range searchTriggers {
+		if _, err := db.Exec(trigger); err != nil {
+			log.Fatalf("error creating search trigger: %v", err)
+		}
+	}
+
+	if indexed {
+		return
+	}
+
+	// Rebuild from scratch: either the index is new, or rows were written
+	// while a build without FTS5 had the triggers dropped
+	if _, err := db.Exec(`DELETE FROM posts_fts; INSERT INTO posts_fts (post_id, title, content) SELECT id, title, content FROM posts`); err != nil {
+		log.Printf("Warning: Could not backfill post search index: %v", err)
+	}
+	if _, err := db.Exec(`DELETE FROM comments_fts; INSERT INTO comments_fts (comment_id, content) SELECT id, content FROM comments`); err != nil {
+		log.Printf("Warning: Could not backfill comment search index: %v", err)
+	}
+	log.Println("Search index built")
+}
+
+// fts5Available reports whether this SQLite build includes the FTS5 module
+func fts5Available(db *sql.DB) bool {
+	var enabled bool
+	err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&enabled)
+	return err == nil && enabled
+}
+
+// dropSearchTriggers removes the triggers that feed the FTS5 index. The
+// virtual tables are left alone: SQLite cannot drop them without the module.
+func dropSearchTriggers(db *sql.DB) {
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'trigger' AND name LIKE '%\_fts\_%' ESCAPE '\'`)
+	if err != nil {
+		log.Printf("Warning: Could not list search triggers: %v", err)
+		return
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err == nil {
+			names = append(names, name)
+		}
+	}
+	rows.Close()
+
+	for _, name := range names {
+		if _, err := db.Exec(`DROP TRIGGER IF EXISTS "` + name + `"`); err != nil {
+			log.Fatalf("error dropping search trigger %s: %v", name, err)
+		}
+	}
+}
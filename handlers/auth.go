@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"real-time-forum/models"
+	"real-time-forum/mailer"
 	"strings"
 	"time"
 
@@ -35,14 +35,18 @@ func CheckAuthHandler(db *sql.DB) http.HandlerFunc {
 		// Session is valid
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"authenticated": true,
-			"user_id":       session.UserID,
-			"nickname":      session.Nickname,
+			"authenticated":  true,
+			"user_id":        session.UserID,
+			"nickname":       session.Nickname,
+			"email_verified": session.EmailVerified,
+			"role":           session.Role,
 		})
 	}
 }
 
-func LoginHandler(db *sql.DB) http.HandlerFunc {
+// LoginHandler checks credentials and starts a session, or a pending login for
+// accounts with two-factor authentication. Failed attempts are throttled by limiter.
+func LoginHandler(db *sql.DB, limiter *LoginLimiter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Println("Login request received")
 
@@ -79,6 +83,7 @@ func LoginHandler(db *sql.DB) http.HandlerFunc {
 		}
 
 		var userID, storedNickname, passwordHash string
+		var twoFactor bool
 		var err error
 
 		if loginType == "email" {
@@ -86,36 +91,62 @@ func LoginHandler(db *sql.DB) http.HandlerFunc {
 				http.Error(w, "Email required", http.StatusBadRequest)
 				return
 			}
-			err = db.QueryRow(`SELECT id, nickname, password_hash FROM users WHERE email = ?`, email).
-				Scan(&userID, &storedNickname, &passwordHash)
+			err = db.QueryRow(`SELECT id, nickname, password_hash, totp_enabled_at IS NOT NULL FROM users WHERE email = ?`, email).
+				Scan(&userID, &storedNickname, &passwordHash, &twoFactor)
 		} else { // nickname
 			if nickname == "" {
 				http.Error(w, "Nickname required", http.StatusBadRequest)
 				return
 			}
-			err = db.QueryRow(`SELECT id, nickname, password_hash FROM users WHERE nickname = ?`, nickname).
-				Scan(&userID, &storedNickname, &passwordHash)
+			err = db.QueryRow(`SELECT id, nickname, password_hash, totp_enabled_at IS NOT NULL FROM users WHERE nickname = ?`, nickname).
+				Scan(&userID, &storedNickname, &passwordHash, &twoFactor)
+		}
+
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Database error: %v", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		// Throttle by account, whichever identifier was used, and by client IP.
+		// Unknown identifiers are throttled too so probing them costs the same.
+		ip := clientIP(r)
+		account := userID
+		if err == sql.ErrNoRows {
+			account = strings.ToLower(email + nickname)
+		}
+		if wait := limiter.RetryAfter(account, ip); wait > 0 {
+			writeTooManyAttempts(w, wait)
+			return
 		}
 
 		if err == sql.ErrNoRows {
 			log.Println("User not found")
+			recordLockouts(db, limiter.RecordFailure(account, ip))
 			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 			return
-		} else if err != nil {
-			log.Printf("Database error: %v", err)
-			http.Error(w, "Server error", http.StatusInternalServerError)
-			return
 		}
 
 		// Compare password
 		if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)); err != nil {
 			log.Println("Password mismatch")
+			recordLockouts(db, limiter.RecordFailure(account, ip))
 			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 			return
 		}
+		remember := isRememberMe(r.FormValue("remember"))
+
+		// Accounts with 2FA get a pending login instead of a session. Their
+		// failures are only cleared once the second factor checks out, or a
+		// known password would reset the counter for guessing codes.
+		if twoFactor {
+			startPendingLogin(db, w, userID, remember)
+			return
+		}
+		limiter.RecordSuccess(account)
 
 		// Create session
-		sessionID, err := CreateSession(db, w, userID, storedNickname)
+		sessionID, err := CreateSession(db, w, r, userID, storedNickname, remember)
 		if err != nil {
 			log.Printf("Session creation error: %v", err)
 			http.Error(w, "Failed to create session", http.StatusInternalServerError)
@@ -127,6 +158,15 @@ func LoginHandler(db *sql.DB) http.HandlerFunc {
 	}
 }
 
+// isRememberMe reads the login form's "remember me" checkbox
+func isRememberMe(value string) bool {
+	switch strings.ToLower(value) {
+	case "1", "true", "on", "yes":
+		return true
+	}
+	return false
+}
+
 func LogoutHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Println("Logout request received")
@@ -144,7 +184,8 @@ func LogoutHandler(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-func SignupHandler(db *sql.DB) http.HandlerFunc {
+// SignupHandler creates an unverified account and emails it a verification link
+func SignupHandler(db *sql.DB, mail mailer.Mailer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "POST" {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -188,6 +229,11 @@ func SignupHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		// The account exists either way; a failed send can be retried through the resend endpoint
+		if err := sendVerificationEmail(db, mail, user.ID, user.Nickname, user.Email); err != nil {
+			log.Printf("Error sending verification email: %v", err)
+		}
+
 		w.WriteHeader(http.StatusCreated)
 		fmt.Fprintf(w, "User created successfully")
 	}
@@ -202,8 +248,8 @@ func getFormValue(r *http.Request, keys []string) string {
 	return ""
 }
 
-// OnlineUsersHandler returns list of online users active in the last 5 minutes
-func OnlineUsersHandler(db *sql.DB) http.HandlerFunc {
+// OnlineUsersHandler returns the users with an open WebSocket connection, excluding the caller
+func OnlineUsersHandler(db *sql.DB, connManager *ConnectionManager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
@@ -217,41 +263,7 @@ func OnlineUsersHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		// Update current user's last_active
-		UpdateLastActive(db, w, r)
-
-		fiveMinutesAgo := time.Now().Add(-5 * time.Minute)
-
-		// Get online users EXCLUDING current user
-		rows, err := db.Query(`
-			SELECT DISTINCT s.user_id, s.nickname 
-			FROM sessions s
-			WHERE s.expires_at > ? AND s.last_active > ? AND s.user_id != ?
-			ORDER BY s.last_active DESC
-		`, time.Now(), fiveMinutesAgo, session.UserID)
-
-		if err != nil {
-			log.Printf("Database error: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{
-				"error": "Database error",
-			})
-			return
-		}
-		defer rows.Close()
-
-		var users []models.OnlineUser
-		for rows.Next() {
-			var user models.OnlineUser
-			if err := rows.Scan(&user.ID, &user.Nickname); err != nil {
-				log.Printf("Error scanning user data: %v", err)
-				continue
-			}
-			users = append(users, user)
-		}
-
-		log.Printf("Found %d online users (excluding current user)", len(users))
-		json.NewEncoder(w).Encode(users)
+		json.NewEncoder(w).Encode(connManager.OnlineUsers(session.UserID))
 	}
 }
 
@@ -270,9 +282,11 @@ func CurrentUserHandler(db *sql.DB) http.HandlerFunc {
 		}
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success": true,
-			"user": map[string]string{
-				"id":       session.UserID,
-				"nickname": session.Nickname,
+			"user": map[string]interface{}{
+				"id":             session.UserID,
+				"nickname":       session.Nickname,
+				"email_verified": session.EmailVerified,
+				"role":           session.Role,
 			},
 		})
 	}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"real-time-forum/models"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// categorySlugPattern keeps slugs URL-safe: lowercase letters, digits and dashes
+var categorySlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// CategoriesHandler lists categories in display order with their post counts.
+// Archived categories are only included when ?archived=1 is passed.
+func CategoriesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		session := GetSession(db, r)
+		if session == nil || session.ExpiresAt.Before(time.Now()) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		categories, err := listCategories(db, session, r.URL.Query().Get("archived") == "1")
+		if err != nil {
+			log.Printf("Database error loading categories: %v", err)
+			http.Error(w, "Failed to fetch categories", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(categories)
+	}
+}
+
+// AdminCategoriesHandler creates a category on POST and updates one on PUT/PATCH
+// (?slug= selects it). Updates can rename it, change its description or sort
+// order, and archive it so no new posts can be filed under it.
+func AdminCategoriesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := requirePermission(db, w, r, PermManageCategories)
+		if session == nil {
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPost:
+			handleCreateCategory(db, w, r)
+		case http.MethodPut, http.MethodPatch:
+			handleUpdateCategory(db, w, r, session)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func handleCreateCategory(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	var c models.Category
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "Invalid category data", http.StatusBadRequest)
+		return
+	}
+	c.Slug = strings.TrimSpace(c.Slug)
+	c.Name = strings.TrimSpace(c.Name)
+	if !categorySlugPattern.MatchString(c.Slug) {
+		http.Error(w, "Slug must be 1-32 lowercase letters, digits or dashes", http.StatusBadRequest)
+		return
+	}
+	if c.Name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO categories (slug, name, description, sort_order, archived)
+		VALUES (?, ?, ?, ?, ?)`,
+		c.Slug, c.Name, c.Description, c.SortOrder, c.Archived,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			http.Error(w, "Category already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to save category", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+func handleUpdateCategory(db *sql.DB, w http.ResponseWriter, r *http.Request, session *models.Session) {
+	slug := r.URL.Query().Get("slug")
+	if slug == "" {
+		http.Error(w, "Category slug is required", http.StatusBadRequest)
+		return
+	}
+
+	var requestData struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+		SortOrder   *int    `json:"sort_order"`
+		Archived    *bool   `json:"archived"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, "Invalid category data", http.StatusBadRequest)
+		return
+	}
+	if requestData.Name != nil {
+		trimmed := strings.TrimSpace(*requestData.Name)
+		if trimmed == "" {
+			http.Error(w, "Name cannot be empty", http.StatusBadRequest)
+			return
+		}
+		requestData.Name = &trimmed
+	}
+
+	res, err := db.Exec(`
+		UPDATE categories SET
+			name = COALESCE(?, name),
+			description = COALESCE(?, description),
+			sort_order = COALESCE(?, sort_order),
+			archived = COALESCE(?, archived)
+		WHERE slug = ?`,
+		requestData.Name, requestData.Description, requestData.SortOrder, requestData.Archived, slug,
+	)
+	if err != nil {
+		http.Error(w, "Failed to update category", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Category not found", http.StatusNotFound)
+		return
+	}
+
+	c, err := getCategory(db, session, slug)
+	if err != nil {
+		http.Error(w, "Failed to fetch category", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// MergeCategoriesHandler moves every post from one category into another and
+// removes the emptied category. Used to clean up duplicates and typos.
+func MergeCategoriesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		session := requirePermission(db, w, r, PermManageCategories)
+		if session == nil {
+			return
+		}
+
+		var requestData struct {
+			From string `json:"from"`
+			Into string `json:"into"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+			http.Error(w, "Invalid merge data", http.StatusBadRequest)
+			return
+		}
+		if requestData.From == "" || requestData.Into == "" || requestData.From == requestData.Into {
+			http.Error(w, "Two different category slugs are required", http.StatusBadRequest)
+			return
+		}
+
+		moved, err := mergeCategories(db, requestData.From, requestData.Into)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Category not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Error merging categories: %v", err)
+			http.Error(w, "Failed to merge categories", http.StatusInternalServerError)
+			return
+		}
+
+		c, err := getCategory(db, session, requestData.Into)
+		if err != nil {
+			http.Error(w, "Failed to fetch category", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"category":    c,
+			"moved_posts": moved,
+		})
+	}
+}
+
+// mergeCategories re-files the posts of from under into and deletes from in
+// one transaction. It returns sql.ErrNoRows if either category is missing.
+func mergeCategories(db *sql.DB, from, into string) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var found int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM categories WHERE slug IN (?, ?)`, from, into).Scan(&found)
+	if err != nil {
+		return 0, err
+	}
+	if found != 2 {
+		return 0, sql.ErrNoRows
+	}
+
+	res, err := tx.Exec(`UPDATE posts SET category_id = ? WHERE category_id = ?`, into, from)
+	if err != nil {
+		return 0, err
+	}
+	moved, _ := res.RowsAffected()
+
+	if _, err = tx.Exec(`DELETE FROM categories WHERE slug = ?`, from); err != nil {
+		return 0, err
+	}
+	return moved, tx.Commit()
+}
+
+// categorySelect selects categories with the number of posts the session can
+// see in each, matching what /api/posts?category= lists for it
+func categorySelect(session *models.Session) string {
+	return `
+		SELECT c.slug, c.name, c.description, c.sort_order, c.archived,
+			(SELECT COUNT(*) FROM posts p WHERE p.category_id = c.slug AND p.deleted_at IS NULL` + hiddenPostFilter(session) + `)
+		FROM categories c`
+}
+
+// listCategories returns categories in display order with their visible post counts
+func listCategories(db *sql.DB, session *models.Session, includeArchived bool) ([]models.Category, error) {
+	rows, err := db.Query(categorySelect(session)+`
+		WHERE ? OR c.archived = 0
+		ORDER BY c.sort_order, c.name`, includeArchived)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []models.Category{}
+	for rows.Next() {
+		var c models.Category
+		if err := rows.Scan(&c.Slug, &c.Name, &c.Description, &c.SortOrder, &c.Archived, &c.PostCount); err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+	return categories, rows.Err()
+}
+
+// getCategory loads a single category with its visible post count
+func getCategory(db *sql.DB, session *models.Session, slug string) (models.Category, error) {
+	var c models.Category
+	err := db.QueryRow(categorySelect(session)+` WHERE c.slug = ?`, slug).
+		Scan(&c.Slug, &c.Name, &c.Description, &c.SortOrder, &c.Archived, &c.PostCount)
+	return c, err
+}
+
+// categoryAcceptsPosts reports whether slug names an existing, non-archived category
+func categoryAcceptsPosts(db *sql.DB, slug string) (bool, error) {
+	var ok bool
+	err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM categories WHERE slug = ? AND archived = 0)`, slug).Scan(&ok)
+	return ok, err
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"real-time-forum/db"
+	"testing"
+)
+
+func TestCategoriesValidateAndMerge(t *testing.T) {
+	dbConn := newTestDB(t)
+	admin := newTestUser(t, dbConn, "admin-id", "admin")
+	alice := newTestUser(t, dbConn, "alice-id", "alice")
+	if err := SetRole(dbConn, "admin", RoleAdmin); err != nil {
+		t.Fatalf("promote admin: %v", err)
+	}
+
+	posts := PostsHandler(dbConn)
+	if rec := doRequest(t, posts, alice, "POST", "/api/posts", `{"title":"t","content":"c","category_id":"golnag"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("post in unknown category: status %d, want 400", rec.Code)
+	}
+
+	adminCategories := AdminCategoriesHandler(dbConn)
+	if rec := doRequest(t, adminCategories, alice, "POST", "/api/admin/categories", `{"slug":"golnag","name":"Typo"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("non-admin create: status %d, want 403", rec.Code)
+	}
+	if rec := doRequest(t, adminCategories, admin, "POST", "/api/admin/categories", `{"slug":"golnag","name":"Typo"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("admin create: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := doRequest(t, posts, alice, "POST", "/api/posts", `{"title":"t","content":"c","category_id":"golnag"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("post in new category: status %d: %s", rec.Code, rec.Body)
+	}
+
+	rec := doRequest(t, MergeCategoriesHandler(dbConn), admin, "POST", "/api/admin/categories/merge", `{"from":"golnag","into":"golang"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("merge: status %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = doRequest(t, CategoriesHandler(dbConn), alice, "GET", "/api/categories", "")
+	var categories []struct {
+		Slug      string `json:"slug"`
+		PostCount int    `json:"post_count"`
+	}
+	json.NewDecoder(rec.Body).Decode(&categories)
+	counts := map[string]int{}
+	for _, c := range categories {
+		counts[c.Slug] = c.PostCount
+	}
+	if _, ok := counts["golnag"]; ok {
+		t.Error("merged category still listed")
+	}
+	if counts["golang"] != 1 {
+		t.Errorf("golang has %d posts, want 1 after merge", counts["golang"])
+	}
+}
+
+func TestMergedDefaultCategoryStaysGone(t *testing.T) {
+	dbConn := newTestDB(t)
+	admin := newTestUser(t, dbConn, "admin-id", "admin")
+	if err := SetRole(dbConn, "admin", RoleAdmin); err != nil {
+		t.Fatalf("promote admin: %v", err)
+	}
+
+	rec := doRequest(t, MergeCategoriesHandler(dbConn), admin, "POST", "/api/admin/categories/merge", `{"from":"css","into":"html"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("merge: status %d: %s", rec.Code, rec.Body)
+	}
+
+	// Migrations run again on every startup
+	db.InitializeSchema(dbConn)
+
+	var count int
+	dbConn.QueryRow(`SELECT COUNT(*) FROM categories WHERE slug = 'css'`).Scan(&count)
+	if count != 0 {
+		t.Error("merged default category came back after migrations ran again")
+	}
+}
+
+func TestCategoryPostCountsMatchTheFeed(t *testing.T) {
+	dbConn := newTestDB(t)
+	mod := newTestUser(t, dbConn, "mod-id", "mod")
+	alice := newTestUser(t, dbConn, "alice-id", "alice")
+	if err := SetRole(dbConn, "mod", RoleModerator); err != nil {
+		t.Fatalf("promote mod: %v", err)
+	}
+	_, err := dbConn.Exec(`
+		INSERT INTO posts (id, user_id, category_id, title, content, hidden_at, deleted_at) VALUES
+			('live', 'alice-id', 'golang', 't', 'c', NULL, NULL),
+			('hidden', 'alice-id', 'golang', 't', 'c', CURRENT_TIMESTAMP, NULL),
+			('deleted', 'alice-id', 'golang', 't', 'c', NULL, CURRENT_TIMESTAMP);`)
+	if err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	golangCount := func(cookie *http.Cookie) int {
+		t.Helper()
+		var categories []struct {
+			Slug      string `json:"slug"`
+			PostCount int    `json:"post_count"`
+		}
+		json.NewDecoder(doRequest(t, CategoriesHandler(dbConn), cookie, "GET", "/api/categories", "").Body).Decode(&categories)
+		for _, c := range categories {
+			if c.Slug == "golang" {
+				return c.PostCount
+			}
+		}
+		t.Fatal("golang category not listed")
+		return 0
+	}
+	feedCount := func(cookie *http.Cookie) int {
+		t.Helper()
+		var body struct {
+			Posts []json.RawMessage `json:"posts"`
+		}
+		json.NewDecoder(doRequest(t, PostsHandler(dbConn), cookie, "GET", "/api/posts?category=golang", "").Body).Decode(&body)
+		return len(body.Posts)
+	}
+
+	if got, feed := golangCount(alice), feedCount(alice); got != 1 || feed != 1 {
+		t.Errorf("user sees a count of %d and %d posts, want 1 and 1", got, feed)
+	}
+	if got, feed := golangCount(mod), feedCount(mod); got != 2 || feed != 2 {
+		t.Errorf("moderator sees a count of %d and %d posts, want 2 and 2", got, feed)
+	}
+}
@@ -7,8 +7,12 @@ import (
 	"net/http"
 	"real-time-forum/models"
 	"strconv"
+	"time"
 )
 
+// conversationPreviewLength caps the last-message preview in the inbox, in characters
+const conversationPreviewLength = 100
+
 func HandleChatRequest(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		session := GetSession(db, r)
@@ -16,6 +20,9 @@ func HandleChatRequest(db *sql.DB) http.HandlerFunc {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
+		if !requireVerified(w, session, ActionChat) {
+			return
+		}
 
 		user2_id := r.URL.Query().Get("user")
 		if user2_id == "" || user2_id == session.UserID {
@@ -36,7 +43,9 @@ func HandleChatRequest(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-func HandleChatHistory(db *sql.DB) http.HandlerFunc {
+// HandleChatHistory returns a page of a chat's messages. Loading the latest page
+// marks the chat read and sends the other participant a read receipt.
+func HandleChatHistory(db *sql.DB, connManager *ConnectionManager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
@@ -119,15 +128,136 @@ func HandleChatHistory(db *sql.DB) http.HandlerFunc {
 
 		log.Printf("Loaded %d messages for chatId=%d", len(messages), chatId)
 
+		// Opening the latest page of a chat counts as reading it
+		if before == "" && len(messages) > 0 {
+			lastID := messages[len(messages)-1].ID
+			if err := markChatRead(db, chatId, session.UserID, lastID); err != nil {
+				log.Printf("Error updating read cursor: %v", err)
+			} else {
+				connManager.SendToUser(user2, map[string]interface{}{
+					"type":      "read",
+					"chatId":    chatId,
+					"readerId":  session.UserID,
+					"messageId": lastID,
+				})
+			}
+		}
+
+		// The other participant's read cursor lets clients render "seen" markers after a reload
+		otherReadID, err := getReadCursor(db, chatId, user2)
+		if err != nil {
+			log.Printf("Error loading read cursor: %v", err)
+		}
+
 		response := map[string]interface{}{
-			"success":  true,
-			"messages": messages,
+			"success":               true,
+			"messages":              messages,
+			"other_read_message_id": otherReadID,
 		}
 
 		json.NewEncoder(w).Encode(response)
 	}
 }
 
+// HandleConversations lists the caller's chats with the other participant, a preview
+// of the last message and the unread count, most recently active first
+func HandleConversations(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		session := GetSession(db, r)
+		if session == nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "Unauthorized",
+			})
+			return
+		}
+
+		// Message IDs only ever grow, so the newest message ID doubles as the activity order
+		rows, err := db.Query(`
+            SELECT c.id, u.id, u.nickname,
+                COALESCE(m.id, 0), COALESCE(m.content, ''), COALESCE(m.sender_id, ''), m.sent_at, c.created_at,
+                (SELECT COUNT(*) FROM messages um
+                 WHERE um.chat_id = c.id AND um.sender_id != ? AND um.id > COALESCE(cr.last_read_message_id, 0))
+            FROM chats c
+            JOIN users u ON u.id = CASE WHEN c.user1_id = ? THEN c.user2_id ELSE c.user1_id END
+            LEFT JOIN messages m ON m.id = (SELECT MAX(id) FROM messages WHERE chat_id = c.id)
+            LEFT JOIN chat_reads cr ON cr.chat_id = c.id AND cr.user_id = ?
+            WHERE c.user1_id = ? OR c.user2_id = ?
+            ORDER BY m.id IS NULL, m.id DESC, c.id DESC
+        `, session.UserID, session.UserID, session.UserID, session.UserID, session.UserID)
+		if err != nil {
+			log.Printf("Database error loading conversations: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error": "Failed to load conversations",
+			})
+			return
+		}
+		defer rows.Close()
+
+		conversations := []models.Conversation{}
+		for rows.Next() {
+			var conv models.Conversation
+			var lastSent sql.NullTime
+			var chatCreated time.Time
+			if err := rows.Scan(&conv.ChatID, &conv.UserID, &conv.Nickname, &conv.LastMessageID, &conv.LastMessage,
+				&conv.LastSenderID, &lastSent, &chatCreated, &conv.UnreadCount); err != nil {
+				log.Printf("Error scanning conversation: %v", err)
+				continue
+			}
+			if preview := []rune(conv.LastMessage); len(preview) > conversationPreviewLength {
+				conv.LastMessage = string(preview[:conversationPreviewLength]) + "…"
+			}
+			if lastSent.Valid {
+				conv.LastMessageTime = lastSent.Time.Format(time.RFC3339)
+			} else {
+				conv.LastMessageTime = chatCreated.Format(time.RFC3339)
+			}
+			conversations = append(conversations, conv)
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":       true,
+			"conversations": conversations,
+		})
+	}
+}
+
+// markChatRead moves the user's read cursor for a chat forward to messageID; it never moves backwards
+func markChatRead(db *sql.DB, chatID int, userID string, messageID int) error {
+	_, err := db.Exec(`
+        INSERT INTO chat_reads (chat_id, user_id, last_read_message_id, updated_at)
+        VALUES (?, ?, ?, ?)
+        ON CONFLICT(chat_id, user_id) DO UPDATE SET
+            last_read_message_id = MAX(last_read_message_id, excluded.last_read_message_id),
+            updated_at = excluded.updated_at`,
+		chatID, userID, messageID, time.Now(),
+	)
+	return err
+}
+
+// getReadCursor returns the highest message ID the user has read in a chat, or 0
+func getReadCursor(db *sql.DB, chatID int, userID string) (int, error) {
+	var messageID int
+	err := db.QueryRow(`
+        SELECT last_read_message_id FROM chat_reads WHERE chat_id = ? AND user_id = ?`,
+		chatID, userID,
+	).Scan(&messageID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return messageID, err
+}
+
+// getChatParticipants returns both user IDs of a chat
+func getChatParticipants(db *sql.DB, chatID int) (string, string, error) {
+	var user1, user2 string
+	err := db.QueryRow(`SELECT user1_id, user2_id FROM chats WHERE id = ?`, chatID).Scan(&user1, &user2)
+	return user1, user2, err
+}
+
 func findOrCreateChat(db *sql.DB, user1, user2 string) (int, error) {
 	var chatId int
 	err := db.QueryRow(`
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"real-time-forum/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// sendChatMessage stores a message and returns its ID
+func sendChatMessage(t *testing.T, dbConn *sql.DB, chatID int, senderID, content string) int {
+	t.Helper()
+	res, err := dbConn.Exec(`INSERT INTO messages (chat_id, sender_id, content) VALUES (?, ?, ?)`, chatID, senderID, content)
+	if err != nil {
+		t.Fatalf("insert message: %v", err)
+	}
+	id, _ := res.LastInsertId()
+	return int(id)
+}
+
+func TestConversationsOrderByActivityAndCountUnread(t *testing.T) {
+	dbConn := newTestDB(t)
+	alice := newTestUser(t, dbConn, "alice-id", "alice")
+	bob := newTestUser(t, dbConn, "bob-id", "bob")
+	newTestUser(t, dbConn, "carol-id", "carol")
+	newTestUser(t, dbConn, "dave-id", "dave")
+
+	chat := func(other string) int {
+		t.Helper()
+		id, err := findOrCreateChat(dbConn, "alice-id", other)
+		if err != nil {
+			t.Fatalf("create chat: %v", err)
+		}
+		return id
+	}
+	withBob, withCarol := chat("bob-id"), chat("carol-id")
+	chat("dave-id") // no messages yet, listed last
+
+	first := sendChatMessage(t, dbConn, withBob, "bob-id", "hi alice")
+	sendChatMessage(t, dbConn, withBob, "bob-id", "are you there?")
+	sendChatMessage(t, dbConn, withCarol, "carol-id", "lunch?")
+
+	// inbox renders a user's conversations as "nickname:unread" in list order
+	inbox := func(cookie *http.Cookie) string {
+		t.Helper()
+		rec := doRequest(t, HandleConversations(dbConn), cookie, "GET", "/api/chat/conversations", "")
+		var body struct {
+			Conversations []models.Conversation `json:"conversations"`
+		}
+		json.NewDecoder(rec.Body).Decode(&body)
+		var parts []string
+		for _, c := range body.Conversations {
+			parts = append(parts, fmt.Sprintf("%s:%d", c.Nickname, c.UnreadCount))
+		}
+		return strings.Join(parts, " ")
+	}
+
+	if got := inbox(alice); got != "carol:1 bob:2 dave:0" {
+		t.Errorf("inbox = %s, want carol:1 bob:2 dave:0", got)
+	}
+
+	if err := markChatRead(dbConn, withBob, "alice-id", first); err != nil {
+		t.Fatalf("mark read: %v", err)
+	}
+	if got := inbox(alice); got != "carol:1 bob:1 dave:0" {
+		t.Errorf("inbox after reading one message = %s, want carol:1 bob:1 dave:0", got)
+	}
+
+	// Replying moves the chat to the top; the reply is not unread for its sender
+	reply := sendChatMessage(t, dbConn, withBob, "alice-id", "yes!")
+	if got := inbox(alice); got != "bob:1 carol:1 dave:0" {
+		t.Errorf("inbox after replying = %s, want bob:1 carol:1 dave:0", got)
+	}
+	if got := inbox(bob); got != "alice:1" {
+		t.Errorf("bob's inbox = %s, want alice:1", got)
+	}
+
+	if err := markChatRead(dbConn, withBob, "alice-id", reply); err != nil {
+		t.Fatalf("mark read: %v", err)
+	}
+	if got := inbox(alice); got != "bob:0 carol:1 dave:0" {
+		t.Errorf("inbox after reading the chat = %s, want bob:0 carol:1 dave:0", got)
+	}
+}
+
+func TestChatHistoryAdvancesReadCursorAndSendsReceipt(t *testing.T) {
+	dbConn := newTestDB(t)
+	alice := newTestUser(t, dbConn, "alice-id", "alice")
+	bob := newTestUser(t, dbConn, "bob-id", "bob")
+	chatID, err := findOrCreateChat(dbConn, "alice-id", "bob-id")
+	if err != nil {
+		t.Fatalf("create chat: %v", err)
+	}
+	first := sendChatMessage(t, dbConn, chatID, "bob-id", "hi alice")
+	last := sendChatMessage(t, dbConn, chatID, "bob-id", "are you there?")
+
+	connManager := NewConnectionManager()
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	server := httptest.NewServer(HandleWebSocket(dbConn, connManager, upgrader))
+	t.Cleanup(server.Close)
+	bobWS := dialWS(t, server, bob)
+	time.Sleep(50 * time.Millisecond)
+
+	history := HandleChatHistory(dbConn, connManager)
+
+	rec := doRequest(t, history, alice, "GET", "/api/chat/history?receiverId=bob-id", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("history: status %d: %s", rec.Code, rec.Body)
+	}
+	if cursor, _ := getReadCursor(dbConn, chatID, "alice-id"); cursor != last {
+		t.Errorf("read cursor = %d, want %d", cursor, last)
+	}
+	frame := readFrame(t, bobWS)
+	if frame["type"] != "read" || frame["readerId"] != "alice-id" ||
+		frame["chatId"] != float64(chatID) || frame["messageId"] != float64(last) {
+		t.Errorf("bob got %v, want a read receipt for message %d", frame, last)
+	}
+
+	// The cursor never moves backwards
+	if err := markChatRead(dbConn, chatID, "alice-id", first); err != nil {
+		t.Fatalf("mark read: %v", err)
+	}
+	if cursor, _ := getReadCursor(dbConn, chatID, "alice-id"); cursor != last {
+		t.Errorf("read cursor after an older receipt = %d, want %d", cursor, last)
+	}
+
+	// An older page is not the latest, so it sends no receipt
+	doRequest(t, history, alice, "GET", fmt.Sprintf("/api/chat/history?receiverId=bob-id&before=%d", last), "")
+	expectNoFrame(t, bobWS)
+
+	// Bob sees alice's cursor when he loads the chat
+	var body struct {
+		OtherReadMessageID int `json:"other_read_message_id"`
+	}
+	json.NewDecoder(doRequest(t, history, bob, "GET", "/api/chat/history?receiverId=alice-id", "").Body).Decode(&body)
+	if body.OtherReadMessageID != last {
+		t.Errorf("other_read_message_id = %d, want %d", body.OtherReadMessageID, last)
+	}
+}
@@ -10,7 +10,48 @@ import (
 	"github.com/gofrs/uuid"
 )
 
-// CreateComment handles adding a new comment to a post
+// MaxCommentDepth is the deepest reply level accepted; top-level comments are depth 0
+var MaxCommentDepth = 5
+
+// deletedCommentText replaces the content of a deleted comment that still has replies
+const deletedCommentText = "[deleted]"
+
+// CommentsHandler creates comments on POST, edits the caller's comment on PUT/PATCH
+// and deletes it on DELETE. Edits and deletes take the comment ID as ?id=.
+func CommentsHandler(db *sql.DB) http.HandlerFunc {
+	create := CreateComment(db)
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			create(w, r)
+			return
+		case http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		session := GetSession(db, r)
+		if session == nil || session.ExpiresAt.Before(time.Now()) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		commentID := r.URL.Query().Get("id")
+		if commentID == "" {
+			http.Error(w, "Comment ID is required", http.StatusBadRequest)
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			handleDeleteComment(db, w, commentID, session)
+		} else if requireVerified(w, session, ActionComment) {
+			handleUpdateComment(db, w, r, commentID, session)
+		}
+	}
+}
+
+// CreateComment handles adding a new comment to a post, optionally as a reply to another comment
 func CreateComment(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
@@ -30,10 +71,14 @@ func CreateComment(db *sql.DB) http.HandlerFunc {
 			http.Error(w, "Invalid session", http.StatusUnauthorized)
 			return
 		}
+		if !requireVerified(w, session, ActionComment) {
+			return
+		}
 
 		var requestData struct {
-			PostID  string `json:"post_id"`
-			Content string `json:"body"` // Accept 'body' from frontend but use 'content' internally
+			PostID   string `json:"post_id"`
+			ParentID string `json:"parent_id"` // Optional, set when replying to a comment
+			Content  string `json:"body"`      // Accept 'body' from frontend but use 'content' internally
 		}
 
 		err := json.NewDecoder(r.Body).Decode(&requestData)
@@ -48,13 +93,51 @@ func CreateComment(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		// Verify the post exists
-		var postExists bool
-		err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM posts WHERE id = ?)", requestData.PostID).Scan(&postExists)
-		if err != nil || !postExists {
+		// Verify the post exists and is open for comments; moderators can still comment on locked posts
+		post, err := loadPostState(db, session, requestData.PostID)
+		if err != nil {
 			http.Error(w, "Post not found", http.StatusNotFound)
 			return
 		}
+		if post.Locked && !can(session, PermModerate) {
+			http.Error(w, "Post is locked", http.StatusForbidden)
+			return
+		}
+
+		// Replies must target a comment on the same post and stay within the depth limit
+		depth := 0
+		if requestData.ParentID != "" {
+			var parentPostID string
+			var parentDeleted bool
+			err = db.QueryRow("SELECT post_id, deleted_at IS NOT NULL FROM comments WHERE id = ?", requestData.ParentID).
+				Scan(&parentPostID, &parentDeleted)
+			if err == sql.ErrNoRows {
+				http.Error(w, "Parent comment not found", http.StatusNotFound)
+				return
+			} else if err != nil {
+				http.Error(w, "Failed to fetch parent comment", http.StatusInternalServerError)
+				return
+			}
+			if parentPostID != requestData.PostID {
+				http.Error(w, "Parent comment belongs to a different post", http.StatusBadRequest)
+				return
+			}
+			if parentDeleted {
+				http.Error(w, "Cannot reply to a deleted comment", http.StatusBadRequest)
+				return
+			}
+
+			parentDepth, err := commentDepth(db, requestData.ParentID)
+			if err != nil {
+				http.Error(w, "Failed to fetch parent comment", http.StatusInternalServerError)
+				return
+			}
+			depth = parentDepth + 1
+			if depth > MaxCommentDepth {
+				http.Error(w, "Maximum reply depth reached", http.StatusBadRequest)
+				return
+			}
+		}
 
 		// Generate UUID and create comment
 		commentID, err := uuid.NewV4()
@@ -70,13 +153,17 @@ func CreateComment(db *sql.DB) http.HandlerFunc {
 			Nickname:  session.Nickname,
 			Content:   requestData.Content,
 			CreatedAt: time.Now(),
+			Depth:     depth,
+		}
+		if requestData.ParentID != "" {
+			comment.ParentID = &requestData.ParentID
 		}
 
 		// Insert into database
 		_, err = db.Exec(`
-			INSERT INTO comments (id, post_id, user_id, nickname, content, created_at)
-			VALUES (?, ?, ?, ?, ?, ?)
-		`, comment.ID, comment.PostID, comment.UserID, comment.Nickname, comment.Content, comment.CreatedAt)
+			INSERT INTO comments (id, post_id, user_id, nickname, content, created_at, parent_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, comment.ID, comment.PostID, comment.UserID, comment.Nickname, comment.Content, comment.CreatedAt, comment.ParentID)
 
 		if err != nil {
 			http.Error(w, "Failed to save comment", http.StatusInternalServerError)
@@ -87,3 +174,200 @@ func CreateComment(db *sql.DB) http.HandlerFunc {
 		json.NewEncoder(w).Encode(comment)
 	}
 }
+
+// handleUpdateComment replaces the content of one of the caller's comments
+func handleUpdateComment(db *sql.DB, w http.ResponseWriter, r *http.Request, commentID string, session *models.Session) {
+	var requestData struct {
+		Content string `json:"body"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, "Invalid comment data", http.StatusBadRequest)
+		return
+	}
+	if requestData.Content == "" {
+		http.Error(w, "Comment content is required", http.StatusBadRequest)
+		return
+	}
+
+	if !authorizeCommentAuthor(db, w, commentID, session) {
+		return
+	}
+
+	_, err := db.Exec(`UPDATE comments SET content = ?, edited_at = ? WHERE id = ? AND deleted_at IS NULL`,
+		requestData.Content, time.Now(), commentID)
+	if err != nil {
+		http.Error(w, "Failed to update comment", http.StatusInternalServerError)
+		return
+	}
+
+	var c models.Comment
+	err = db.QueryRow(`
+		SELECT id, post_id, user_id, nickname, content, created_at, parent_id, edited_at
+		FROM comments WHERE id = ?
+	`, commentID).Scan(&c.ID, &c.PostID, &c.UserID, &c.Nickname, &c.Content, &c.CreatedAt, &c.ParentID, &c.EditedAt)
+	if err != nil {
+		http.Error(w, "Failed to fetch comment", http.StatusInternalServerError)
+		return
+	}
+	if c.Depth, err = commentDepth(db, commentID); err != nil {
+		http.Error(w, "Failed to fetch comment", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// handleDeleteComment removes one of the caller's comments. A comment with
+// replies is kept as a "[deleted]" tombstone so the thread stays intact.
+func handleDeleteComment(db *sql.DB, w http.ResponseWriter, commentID string, session *models.Session) {
+	if !authorizeCommentAuthor(db, w, commentID, session) {
+		return
+	}
+
+	tombstoned, err := deleteComment(db, commentID)
+	if err != nil {
+		http.Error(w, "Failed to delete comment", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":         commentID,
+		"deleted":    true,
+		"tombstoned": tombstoned,
+	})
+}
+
+// authorizeCommentAuthor checks that the comment exists, is not deleted and
+// belongs to the caller, writing the error response and returning false otherwise
+func authorizeCommentAuthor(db *sql.DB, w http.ResponseWriter, commentID string, session *models.Session) bool {
+	var authorID string
+	err := db.QueryRow(`SELECT user_id FROM comments WHERE id = ? AND deleted_at IS NULL`, commentID).Scan(&authorID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return false
+	} else if err != nil {
+		http.Error(w, "Failed to fetch comment", http.StatusInternalServerError)
+		return false
+	}
+	if authorID != session.UserID {
+		http.Error(w, "You can only modify your own comments", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// deleteComment tombstones the comment if it has replies and removes it
+// otherwise. Removing a leaf can leave its parent as a tombstone with nothing
+// under it, so empty tombstones are pruned up the thread as well.
+func deleteComment(db *sql.DB, commentID string) (tombstoned bool, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var replies int
+	if err = tx.QueryRow(`SELECT COUNT(*) FROM comments WHERE parent_id = ?`, commentID).Scan(&replies); err != nil {
+		return false, err
+	}
+
+	if replies > 0 {
+		_, err = tx.Exec(`UPDATE comments SET content = '', deleted_at = ? WHERE id = ?`, time.Now(), commentID)
+		if err != nil {
+			return false, err
+		}
+		return true, tx.Commit()
+	}
+
+	id := commentID
+	for {
+		var parentID sql.NullString
+		if err = tx.QueryRow(`SELECT parent_id FROM comments WHERE id = ?`, id).Scan(&parentID); err != nil {
+			return false, err
+		}
+		if _, err = tx.Exec(`DELETE FROM comments WHERE id = ?`, id); err != nil {
+			return false, err
+		}
+		if !parentID.Valid {
+			break
+		}
+
+		// Stop at the first ancestor that is still live or still has other replies
+		var prune bool
+		err = tx.QueryRow(`
+			SELECT deleted_at IS NOT NULL AND NOT EXISTS(SELECT 1 FROM comments WHERE parent_id = c.id)
+			FROM comments c WHERE c.id = ?`, parentID.String).Scan(&prune)
+		if err == sql.ErrNoRows {
+			break
+		} else if err != nil {
+			return false, err
+		}
+		if !prune {
+			break
+		}
+		id = parentID.String
+	}
+	return false, tx.Commit()
+}
+
+// tombstoneComment hides the author and content of a deleted comment
+func tombstoneComment(c *models.Comment) {
+	c.UserID = ""
+	c.Nickname = ""
+	c.Content = deletedCommentText
+	c.EditedAt = nil
+}
+
+// commentDepth returns how many ancestors a comment has (0 for a top-level comment)
+func commentDepth(db *sql.DB, commentID string) (int, error) {
+	var depth int
+	err := db.QueryRow(`
+		WITH RECURSIVE ancestors(id, parent_id, depth) AS (
+			SELECT id, parent_id, 0 FROM comments WHERE id = ?
+			UNION ALL
+			SELECT c.id, c.parent_id, a.depth + 1
+			FROM comments c
+			JOIN ancestors a ON c.id = a.parent_id
+		)
+		SELECT MAX(depth) FROM ancestors
+	`, commentID).Scan(&depth)
+	return depth, err
+}
+
+// threadComments orders comments depth-first so each reply directly follows its
+// parent, filling in Depth. Replies deeper than MaxCommentDepth are flattened to
+// the deepest level, and replies whose parent is missing are treated as top-level.
+func threadComments(comments []models.Comment) []models.Comment {
+	known := make(map[string]bool, len(comments))
+	for _, c := range comments {
+		known[c.ID] = true
+	}
+
+	children := make(map[string][]models.Comment)
+	var roots []models.Comment
+	for _, c := range comments {
+		if c.ParentID != nil && known[*c.ParentID] {
+			children[*c.ParentID] = append(children[*c.ParentID], c)
+		} else {
+			roots = append(roots, c)
+		}
+	}
+
+	threaded := make([]models.Comment, 0, len(comments))
+	var walk func(list []models.Comment, depth int)
+	walk = func(list []models.Comment, depth int) {
+		for _, c := range list {
+			c.Depth = depth
+			if c.Depth > MaxCommentDepth {
+				c.Depth = MaxCommentDepth
+			}
+			threaded = append(threaded, c)
+			walk(children[c.ID], depth+1)
+		}
+	}
+	walk(roots, 0)
+
+	return threaded
+}
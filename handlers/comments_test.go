@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"real-time-forum/models"
+	"strings"
+	"testing"
+)
+
+// postComment creates a comment through the handler and returns its ID
+func postComment(t *testing.T, handler http.HandlerFunc, cookie *http.Cookie, postID, parentID, body string) string {
+	t.Helper()
+	payload, _ := json.Marshal(map[string]string{"post_id": postID, "parent_id": parentID, "body": body})
+	rec := doRequest(t, handler, cookie, "POST", "/api/comments", string(payload))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create comment: status %d: %s", rec.Code, rec.Body)
+	}
+	var c struct {
+		ID string `json:"id"`
+	}
+	json.NewDecoder(rec.Body).Decode(&c)
+	return c.ID
+}
+
+func TestDeleteCommentLeavesTombstoneForReplies(t *testing.T) {
+	dbConn := newTestDB(t)
+	alice := newTestUser(t, dbConn, "alice-id", "alice")
+	bob := newTestUser(t, dbConn, "bob-id", "bob")
+	_, err := dbConn.Exec(`INSERT INTO posts (id, user_id, title, content) VALUES ('post-1', 'alice-id', 't', 'c')`)
+	if err != nil {
+		t.Fatalf("insert post: %v", err)
+	}
+	handler := CommentsHandler(dbConn)
+
+	parent := postComment(t, handler, alice, "post-1", "", "parent")
+	reply := postComment(t, handler, bob, "post-1", parent, "reply")
+
+	if rec := doRequest(t, handler, bob, "DELETE", "/api/comments?id="+parent, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("bob deleting alice's comment: status %d, want 403", rec.Code)
+	}
+	if rec := doRequest(t, handler, alice, "PATCH", "/api/comments?id="+parent, `{"body":"edited"}`); rec.Code != http.StatusOK {
+		t.Errorf("alice edit: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := doRequest(t, handler, alice, "DELETE", "/api/comments?id="+parent, ""); rec.Code != http.StatusOK {
+		t.Fatalf("alice delete: status %d: %s", rec.Code, rec.Body)
+	}
+
+	rec := doRequest(t, GetPostWithComments(dbConn), alice, "GET", "/api/post-details?id=post-1", "")
+	var details struct {
+		Comments []struct {
+			ID      string `json:"id"`
+			Content string `json:"content"`
+			Deleted bool   `json:"deleted"`
+			Depth   int    `json:"depth"`
+		} `json:"comments"`
+	}
+	json.NewDecoder(rec.Body).Decode(&details)
+	if len(details.Comments) != 2 {
+		t.Fatalf("got %d comments, want tombstone and reply", len(details.Comments))
+	}
+	if c := details.Comments[0]; c.ID != parent || !c.Deleted || c.Content != deletedCommentText {
+		t.Errorf("first comment = %+v, want tombstone", c)
+	}
+	if c := details.Comments[1]; c.ID != reply || c.Deleted || c.Depth != 1 {
+		t.Errorf("second comment = %+v, want live reply at depth 1", c)
+	}
+
+	// Removing the last reply also clears the now-empty tombstone
+	if rec := doRequest(t, handler, bob, "DELETE", "/api/comments?id="+reply, ""); rec.Code != http.StatusOK {
+		t.Fatalf("bob delete: status %d: %s", rec.Code, rec.Body)
+	}
+	var remaining int
+	dbConn.QueryRow(`SELECT COUNT(*) FROM comments`).Scan(&remaining)
+	if remaining != 0 {
+		t.Errorf("%d comments left, want 0", remaining)
+	}
+}
+
+func TestCommentRepliesStayOnPostAndWithinDepth(t *testing.T) {
+	dbConn := newTestDB(t)
+	alice := newTestUser(t, dbConn, "alice-id", "alice")
+	_, err := dbConn.Exec(`INSERT INTO posts (id, user_id, title, content) VALUES
+		('post-1', 'alice-id', 't', 'c'), ('post-2', 'alice-id', 't', 'c')`)
+	if err != nil {
+		t.Fatalf("insert posts: %v", err)
+	}
+	defer func(depth int) { MaxCommentDepth = depth }(MaxCommentDepth)
+	MaxCommentDepth = 1
+	handler := CommentsHandler(dbConn)
+
+	top := postComment(t, handler, alice, "post-1", "", "top")
+	reply := postComment(t, handler, alice, "post-1", top, "reply")
+
+	if rec := doRequest(t, handler, alice, "POST", "/api/comments", `{"post_id":"post-2","parent_id":"`+top+`","body":"x"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("reply to a comment on another post: status %d, want 400", rec.Code)
+	}
+	if rec := doRequest(t, handler, alice, "POST", "/api/comments", `{"post_id":"post-1","parent_id":"`+reply+`","body":"x"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("reply past the depth limit: status %d, want 400", rec.Code)
+	}
+}
+
+func TestThreadCommentsOrdersRepliesAndCapsDepth(t *testing.T) {
+	defer func(depth int) { MaxCommentDepth = depth }(MaxCommentDepth)
+	MaxCommentDepth = 1
+
+	parent := func(id string) *string { return &id }
+	// Replies arrive in creation order, interleaved across threads; r0 points at a comment not in the list
+	comments := []models.Comment{
+		{ID: "a"},
+		{ID: "b"},
+		{ID: "a1", ParentID: parent("a")},
+		{ID: "b1", ParentID: parent("b")},
+		{ID: "a1x", ParentID: parent("a1")},
+		{ID: "r0", ParentID: parent("missing")},
+	}
+
+	var got []string
+	for _, c := range threadComments(comments) {
+		got = append(got, fmt.Sprintf("%s:%d", c.ID, c.Depth))
+	}
+	if want := "a:0 a1:1 a1x:1 b:0 b1:1 r0:0"; strings.Join(got, " ") != want {
+		t.Errorf("threaded = %s, want %s", strings.Join(got, " "), want)
+	}
+}
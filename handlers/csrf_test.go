@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	handler := CSRFMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	serve := func(method, origin string, cookie *http.Cookie, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://forum.test/api/posts", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		if token != "" {
+			req.Header.Set(csrfHeaderName, token)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	// Safe requests pass and pick up a token
+	rec := serve("GET", "", nil, "")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("GET: status %d, want 204", rec.Code)
+	}
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == csrfCookieName {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.SameSite != http.SameSiteStrictMode {
+		t.Fatalf("CSRF cookie = %+v, want a SameSite=Strict token", cookie)
+	}
+
+	cases := []struct {
+		name   string
+		origin string
+		cookie *http.Cookie
+		token  string
+		want   int
+	}{
+		{"no token", "http://forum.test", cookie, "", http.StatusForbidden},
+		{"wrong token", "http://forum.test", cookie, "guess", http.StatusForbidden},
+		{"token without cookie", "http://forum.test", nil, cookie.Value, http.StatusForbidden},
+		{"foreign origin", "http://evil.test", cookie, cookie.Value, http.StatusForbidden},
+		{"same origin", "http://forum.test", cookie, cookie.Value, http.StatusNoContent},
+		{"no origin", "", cookie, cookie.Value, http.StatusNoContent},
+	}
+	for _, tc := range cases {
+		if rec := serve("POST", tc.origin, tc.cookie, tc.token); rec.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, rec.Code, tc.want)
+		}
+	}
+
+	AllowedOrigins["http://app.test"] = true
+	defer delete(AllowedOrigins, "http://app.test")
+	if rec := serve("POST", "http://app.test", cookie, cookie.Value); rec.Code != http.StatusNoContent {
+		t.Errorf("allowlisted origin: status %d, want 204", rec.Code)
+	}
+}
+
+func TestOriginAllowedFallsBackToReferer(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://forum.test/ws", nil)
+	req.Header.Set("Referer", "http://evil.test/page")
+	if OriginAllowed(req) {
+		t.Error("foreign Referer allowed")
+	}
+	req.Header.Set("Referer", "http://forum.test/#posts")
+	if !OriginAllowed(req) {
+		t.Error("same-host Referer rejected")
+	}
+}
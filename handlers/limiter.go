@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TrustProxyHeaders makes clientIP believe X-Forwarded-For. Only enable it
+// behind a reverse proxy that sets the header, otherwise clients can forge it.
+var TrustProxyHeaders = false
+
+// LoginPolicy describes how failed logins against one key are slowed down.
+// Each failure below MaxFailures blocks the key for BaseDelay, doubling per
+// failure up to MaxDelay; reaching MaxFailures locks it out for Lockout.
+// Failures are forgotten once Lockout has passed since the last one.
+type LoginPolicy struct {
+	MaxFailures int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Lockout     time.Duration
+}
+
+// LoginLockout describes a key that just reached its failure limit
+type LoginLockout struct {
+	Scope    string // "account" or "ip"
+	Key      string
+	Failures int
+	Until    time.Time
+}
+
+type loginFailures struct {
+	count        int
+	last         time.Time
+	blockedUntil time.Time
+}
+
+// LoginLimiter tracks failed login attempts per account and per client IP.
+// Clients share IPs behind NAT, so the IP policy is usually the looser one.
+type LoginLimiter struct {
+	Account LoginPolicy
+	IP      LoginPolicy
+
+	now      func() time.Time
+	mutex    sync.Mutex
+	failures map[string]*loginFailures
+}
+
+// NewLoginLimiter creates a limiter with default policies. now is the clock it
+// reads; pass nil for time.Now.
+func NewLoginLimiter(now func() time.Time) *LoginLimiter {
+	if now == nil {
+		now = time.Now
+	}
+	return &LoginLimiter{
+		Account:  LoginPolicy{MaxFailures: 5, BaseDelay: time.Second, MaxDelay: 30 * time.Second, Lockout: 15 * time.Minute},
+		IP:       LoginPolicy{MaxFailures: 50, BaseDelay: 0, MaxDelay: 0, Lockout: 15 * time.Minute},
+		now:      now,
+		failures: make(map[string]*loginFailures),
+	}
+}
+
+// NewEmailLimiter creates a limiter for endpoints that send email, such as
+// password resets. Every email requested counts as an attempt, so the address
+// and the client IP are throttled whether or not an account exists.
+func NewEmailLimiter(now func() time.Time) *LoginLimiter {
+	limiter := NewLoginLimiter(now)
+	limiter.Account = LoginPolicy{MaxFailures: 5, BaseDelay: time.Minute, MaxDelay: 10 * time.Minute, Lockout: time.Hour}
+	limiter.IP = LoginPolicy{MaxFailures: 20, BaseDelay: 0, MaxDelay: 0, Lockout: time.Hour}
+	return limiter
+}
+
+// RetryAfter returns how long the account and IP must wait before the next
+// attempt, or 0 if an attempt is allowed now
+func (l *LoginLimiter) RetryAfter(account, ip string) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	var wait time.Duration
+	for _, key := range []string{"account:" + account, "ip:" + ip} {
+		if f, ok := l.failures[key]; ok && f.blockedUntil.After(now) {
+			if d := f.blockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait
+}
+
+// RecordFailure counts a failed attempt against both keys and returns the
+// lockouts it triggered
+func (l *LoginLimiter) RecordFailure(account, ip string) []LoginLockout {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	var lockouts []LoginLockout
+	if lockout := l.fail("account", account, l.Account, now); lockout != nil {
+		lockouts = append(lockouts, *lockout)
+	}
+	if lockout := l.fail("ip", ip, l.IP, now); lockout != nil {
+		lockouts = append(lockouts, *lockout)
+	}
+	l.prune(now)
+	return lockouts
+}
+
+// RecordSuccess clears the account's failures. The IP's are kept, so an
+// attacker can't reset them by logging into an account of their own.
+func (l *LoginLimiter) RecordSuccess(account string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.failures, "account:"+account)
+}
+
+func (l *LoginLimiter) fail(scope, key string, policy LoginPolicy, now time.Time) *LoginLockout {
+	if key == "" || policy.MaxFailures <= 0 {
+		return nil
+	}
+	f, ok := l.failures[scope+":"+key]
+	if !ok || now.Sub(f.last) >= policy.Lockout {
+		f = &loginFailures{}
+		l.failures[scope+":"+key] = f
+	}
+	f.count++
+	f.last = now
+
+	if f.count >= policy.MaxFailures {
+		f.blockedUntil = now.Add(policy.Lockout)
+		return &LoginLockout{Scope: scope, Key: key, Failures: f.count, Until: f.blockedUntil}
+	}
+	delay := time.Duration(float64(policy.BaseDelay) * math.Pow(2, float64(f.count-1)))
+	if delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	f.blockedUntil = now.Add(delay)
+	return nil
+}
+
+// prune drops keys whose failures have been forgotten
+func (l *LoginLimiter) prune(now time.Time) {
+	if len(l.failures) < 1024 {
+		return
+	}
+	for key, f := range l.failures {
+		lockout := l.Account.Lockout
+		if strings.HasPrefix(key, "ip:") {
+			lockout = l.IP.Lockout
+		}
+		if now.Sub(f.last) >= lockout && !f.blockedUntil.After(now) {
+			delete(l.failures, key)
+		}
+	}
+}
+
+// writeTooManyAttempts answers a throttled login with 429 and Retry-After in whole seconds
+func writeTooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	writeThrottled(w, wait, "Too many failed attempts")
+}
+
+// writeTooManyEmails answers a throttled email request the same way
+func writeTooManyEmails(w http.ResponseWriter, wait time.Duration) {
+	writeThrottled(w, wait, "Too many emails requested")
+}
+
+func writeThrottled(w http.ResponseWriter, wait time.Duration, reason string) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", fmt.Sprint(seconds))
+	http.Error(w, fmt.Sprintf("%s, try again in %d seconds", reason, seconds), http.StatusTooManyRequests)
+}
+
+// recordLockouts stores lockout events so admins can spot attacks
+func recordLockouts(db *sql.DB, lockouts []LoginLockout) {
+	for _, lockout := range lockouts {
+		log.Printf("Login lockout for %s %s after %d failures", lockout.Scope, lockout.Key, lockout.Failures)
+		_, err := db.Exec(`
+			INSERT INTO login_lockouts (scope, subject, failures, locked_until, created_at)
+			VALUES (?, ?, ?, ?, ?)`,
+			lockout.Scope, lockout.Key, lockout.Failures, lockout.Until, time.Now(),
+		)
+		if err != nil {
+			log.Printf("Database error recording login lockout: %v", err)
+		}
+	}
+}
+
+// clientIP returns the address the request came from
+func clientIP(r *http.Request) string {
+	if TrustProxyHeaders {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
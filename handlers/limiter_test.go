@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginLimiterBacksOffThenLocksOut(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewLoginLimiter(func() time.Time { return now })
+	limiter.Account = LoginPolicy{MaxFailures: 4, BaseDelay: time.Second, MaxDelay: 3 * time.Second, Lockout: 10 * time.Minute}
+
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+		if lockouts := limiter.RecordFailure("alice", "10.0.0.1"); len(lockouts) != 0 {
+			t.Fatalf("failure %d: unexpected lockout %+v", i+1, lockouts)
+		}
+		if got := limiter.RetryAfter("alice", "10.0.0.1"); got != want {
+			t.Errorf("after failure %d: retry after %v, want %v", i+1, got, want)
+		}
+		// Other accounts from another address are unaffected
+		if got := limiter.RetryAfter("bob", "10.0.0.2"); got != 0 {
+			t.Errorf("bob: retry after %v, want 0", got)
+		}
+		now = now.Add(want)
+	}
+
+	lockouts := limiter.RecordFailure("alice", "10.0.0.1")
+	if len(lockouts) != 1 || lockouts[0].Scope != "account" || lockouts[0].Key != "alice" {
+		t.Fatalf("lockouts = %+v, want alice's account", lockouts)
+	}
+	if got := limiter.RetryAfter("alice", "10.0.0.9"); got != 10*time.Minute {
+		t.Errorf("locked out: retry after %v, want 10m from any address", got)
+	}
+
+	now = now.Add(10 * time.Minute)
+	if got := limiter.RetryAfter("alice", "10.0.0.1"); got != 0 {
+		t.Errorf("after lockout: retry after %v, want 0", got)
+	}
+	limiter.RecordFailure("alice", "10.0.0.1")
+	if got := limiter.RetryAfter("alice", "10.0.0.1"); got != time.Second {
+		t.Errorf("failures not forgotten after lockout: retry after %v, want 1s", got)
+	}
+
+	limiter.RecordSuccess("alice")
+	if got := limiter.RetryAfter("alice", "10.0.0.1"); got != 0 {
+		t.Errorf("after success: retry after %v, want 0", got)
+	}
+}
+
+func TestLoginHandlerThrottlesFailures(t *testing.T) {
+	dbConn := newTestDB(t)
+	newTestUser(t, dbConn, "alice-id", "alice")
+	limiter := NewLoginLimiter(nil)
+	limiter.Account = LoginPolicy{MaxFailures: 2, Lockout: time.Minute}
+
+	login := url.Values{"loginType": {"nickname"}, "nickname": {"alice"}, "password": {"wrong"}}
+	for i := 0; i < 2; i++ {
+		if rec := postForm(LoginHandler(dbConn, limiter), login); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status %d, want 401", i+1, rec.Code)
+		}
+	}
+
+	rec := postForm(LoginHandler(dbConn, limiter), login)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("locked out: status %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+
+	var recorded int
+	dbConn.QueryRow(`SELECT COUNT(*) FROM login_lockouts WHERE scope = 'account' AND subject = 'alice-id'`).Scan(&recorded)
+	if recorded != 1 {
+		t.Errorf("%d lockout events recorded, want 1", recorded)
+	}
+}
+
+func TestPasswordDoesNotResetSecondFactorFailures(t *testing.T) {
+	dbConn := newTestDB(t)
+	newTestUser(t, dbConn, "alice-id", "alice")
+	hash, _ := bcrypt.GenerateFromPassword([]byte("s3cret-pass"), bcrypt.MinCost)
+	secret, _ := newTOTPSecret()
+	dbConn.Exec(`UPDATE users SET password_hash = ?, totp_secret = ?, totp_enabled_at = ? WHERE id = 'alice-id'`,
+		string(hash), secret, time.Now())
+	limiter := NewLoginLimiter(nil)
+	limiter.Account = LoginPolicy{MaxFailures: 5, Lockout: time.Minute}
+
+	login := url.Values{"loginType": {"nickname"}, "nickname": {"alice"}, "password": {"s3cret-pass"}}
+	guess := func() int {
+		rec := postForm(LoginHandler(dbConn, limiter), login)
+		if rec.Code != http.StatusAccepted {
+			return rec.Code
+		}
+		var body struct {
+			PendingToken string `json:"pending_token"`
+		}
+		json.NewDecoder(rec.Body).Decode(&body)
+		for i := 0; i < 3; i++ {
+			rec := postForm(SecondFactorLoginHandler(dbConn, limiter), url.Values{"pending_token": {body.PendingToken}, "code": {"not-a-code"}})
+			if rec.Code != http.StatusUnauthorized {
+				return rec.Code
+			}
+		}
+		return rec.Code
+	}
+
+	// A fresh pending token after three bad codes must not clear the account's failures
+	if code := guess(); code != http.StatusAccepted {
+		t.Fatalf("first round: status %d", code)
+	}
+	guess()
+	if rec := postForm(LoginHandler(dbConn, limiter), login); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("after six bad codes: status %d, want 429", rec.Code)
+	}
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"real-time-forum/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// postModerationActions maps each post moderation action to the columns it sets
+var postModerationActions = map[string]string{
+	"pin":    `pinned_at = ?`,
+	"unpin":  `pinned_at = NULL`,
+	"lock":   `locked_at = ?`,
+	"unlock": `locked_at = NULL`,
+	"hide":   `hidden_at = ?, hidden_reason = ?`,
+	"unhide": `hidden_at = NULL, hidden_reason = NULL`,
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// hiddenPostFilter restricts posts p to the ones the session may see: hidden
+// posts are only listed for moderators
+func hiddenPostFilter(session *models.Session) string {
+	if can(session, PermModerate) {
+		return ""
+	}
+	return " AND p.hidden_at IS NULL"
+}
+
+// postState is the moderation state of a live post
+type postState struct {
+	Hidden bool
+	Locked bool
+}
+
+// loadPostState returns sql.ErrNoRows if the post doesn't exist, was deleted or
+// is hidden from the session
+func loadPostState(db *sql.DB, session *models.Session, postID string) (postState, error) {
+	var s postState
+	err := db.QueryRow(`
+		SELECT hidden_at IS NOT NULL, locked_at IS NOT NULL
+		FROM posts WHERE id = ? AND deleted_at IS NULL`, postID,
+	).Scan(&s.Hidden, &s.Locked)
+	if err == nil && s.Hidden && !can(session, PermModerate) {
+		err = sql.ErrNoRows
+	}
+	return s, err
+}
+
+// logModeration records a moderator action and returns the log entry's ID
+func logModeration(db execer, moderatorID, action, targetType, targetID, reason string) (int64, error) {
+	res, err := db.Exec(`
+		INSERT INTO moderation_log (moderator_id, action, target_type, target_id, reason, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		moderatorID, action, targetType, targetID, reason, time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// moderatePost applies action to a live post and logs it in one transaction.
+// It returns sql.ErrNoRows if the post doesn't exist or was deleted.
+func moderatePost(db *sql.DB, moderatorID, postID, action, reason string) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	logID, err := applyPostModeration(tx, moderatorID, postID, action, reason)
+	if err != nil {
+		return 0, err
+	}
+	return logID, tx.Commit()
+}
+
+// applyPostModeration is moderatePost within the caller's transaction
+func applyPostModeration(tx execer, moderatorID, postID, action, reason string) (int64, error) {
+	var args []interface{}
+	switch action {
+	case "pin", "lock":
+		args = append(args, time.Now())
+	case "hide":
+		args = append(args, time.Now(), reason)
+	}
+	args = append(args, postID)
+
+	res, err := tx.Exec(`UPDATE posts SET `+postModerationActions[action]+` WHERE id = ? AND deleted_at IS NULL`, args...)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, sql.ErrNoRows
+	}
+
+	return logModeration(tx, moderatorID, action, "post", postID, reason)
+}
+
+// ModeratePostHandler pins, locks or hides a post, or undoes one of those.
+// The JSON body is {"post_id", "action", "reason"}; hiding requires a reason.
+func ModeratePostHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		session := requirePermission(db, w, r, PermModerate)
+		if session == nil {
+			return
+		}
+
+		var requestData struct {
+			PostID string `json:"post_id"`
+			Action string `json:"action"`
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+			http.Error(w, "Invalid moderation data", http.StatusBadRequest)
+			return
+		}
+		requestData.Reason = strings.TrimSpace(requestData.Reason)
+		if _, ok := postModerationActions[requestData.Action]; !ok || requestData.PostID == "" {
+			http.Error(w, "Post ID and an action of pin, unpin, lock, unlock, hide or unhide are required", http.StatusBadRequest)
+			return
+		}
+		if requestData.Action == "hide" && requestData.Reason == "" {
+			http.Error(w, "A reason is required to hide a post", http.StatusBadRequest)
+			return
+		}
+
+		logID, err := moderatePost(db, session.UserID, requestData.PostID, requestData.Action, requestData.Reason)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Post not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Error moderating post: %v", err)
+			http.Error(w, "Failed to moderate post", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("%s applied %s to post %s", session.Nickname, requestData.Action, requestData.PostID)
+
+		post, err := scanPost(db.QueryRow(`
+			SELECT `+postColumns+`
+			FROM posts p `+postJoins+`
+			WHERE p.id = ?`, session.UserID, requestData.PostID))
+		if err != nil {
+			http.Error(w, "Failed to fetch post", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"post":   post,
+			"log_id": logID,
+		})
+	}
+}
+
+// ModerationLogHandler lists moderation log entries, newest first.
+//
+// Query parameters: moderator (nickname), action, target_type, target_id,
+// limit, and before (an entry ID, to page back through older entries).
+func ModerationLogHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if requirePermission(db, w, r, PermViewModerationLog) == nil {
+			return
+		}
+
+		query := r.URL.Query()
+		sqlQuery := `
+			SELECT l.id, l.moderator_id, COALESCE(u.nickname, ''), l.action, l.target_type, l.target_id,
+				l.reason, l.created_at
+			FROM moderation_log l
+			LEFT JOIN users u ON u.id = l.moderator_id
+			WHERE 1 = 1`
+		var args []interface{}
+		if moderator := query.Get("moderator"); moderator != "" {
+			sqlQuery += " AND u.nickname = ? COLLATE NOCASE"
+			args = append(args, moderator)
+		}
+		for _, column := range []string{"action", "target_type", "target_id"} {
+			if value := query.Get(column); value != "" {
+				sqlQuery += " AND l." + column + " = ?"
+				args = append(args, value)
+			}
+		}
+		if before := query.Get("before"); before != "" {
+			id, err := strconv.ParseInt(before, 10, 64)
+			if err != nil {
+				http.Error(w, "before must be a log entry ID", http.StatusBadRequest)
+				return
+			}
+			sqlQuery += " AND l.id < ?"
+			args = append(args, id)
+		}
+
+		limit := 50
+		if l := query.Get("limit"); l != "" {
+			if n, err := strconv.Atoi(l); err == nil && n > 0 && n <= 200 {
+				limit = n
+			}
+		}
+		sqlQuery += " ORDER BY l.id DESC LIMIT ?"
+		args = append(args, limit)
+
+		rows, err := db.Query(sqlQuery, args...)
+		if err != nil {
+			log.Printf("Database error loading moderation log: %v", err)
+			http.Error(w, "Failed to fetch moderation log", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		entries := []models.ModerationLogEntry{}
+		for rows.Next() {
+			var e models.ModerationLogEntry
+			if err := rows.Scan(&e.ID, &e.ModeratorID, &e.ModeratorNickname, &e.Action, &e.TargetType, &e.TargetID,
+				&e.Reason, &e.CreatedAt); err != nil {
+				http.Error(w, "Error scanning moderation log", http.StatusInternalServerError)
+				return
+			}
+			entries = append(entries, e)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
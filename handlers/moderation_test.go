@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestModeratorPinLockAndHidePosts(t *testing.T) {
+	dbConn := newTestDB(t)
+	admin := newTestUser(t, dbConn, "admin-id", "admin")
+	mod := newTestUser(t, dbConn, "mod-id", "mod")
+	alice := newTestUser(t, dbConn, "alice-id", "alice")
+	SetRole(dbConn, "admin", RoleAdmin)
+	SetRole(dbConn, "mod", RoleModerator)
+
+	_, err := dbConn.Exec(`
+		INSERT INTO posts (id, user_id, title, content, created_at) VALUES
+			('p1', 'alice-id', 't', 'c', '2026-01-01 10:00:00'),
+			('p2', 'alice-id', 't', 'c', '2026-01-01 11:00:00'),
+			('p3', 'alice-id', 't', 'c', '2026-01-01 12:00:00');`)
+	if err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	moderate := RequireRole(dbConn, RoleModerator, ModeratePostHandler(dbConn))
+	if rec := doRequest(t, moderate, alice, "POST", "/api/moderation/posts", `{"post_id":"p1","action":"pin"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("user pinning: status %d, want 403", rec.Code)
+	}
+	if rec := doRequest(t, moderate, mod, "POST", "/api/moderation/posts", `{"post_id":"p2","action":"hide"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("hide without reason: status %d, want 400", rec.Code)
+	}
+	for _, body := range []string{
+		`{"post_id":"p1","action":"pin"}`,
+		`{"post_id":"p1","action":"lock"}`,
+		`{"post_id":"p2","action":"hide","reason":"spam"}`,
+	} {
+		if rec := doRequest(t, moderate, mod, "POST", "/api/moderation/posts", body); rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", body, rec.Code, rec.Body)
+		}
+	}
+
+	feed := func(cookie *http.Cookie) string {
+		t.Helper()
+		var ids []string
+		cursor := ""
+		for page := 0; page < 5; page++ {
+			rec := doRequest(t, PostsHandler(dbConn), cookie, "GET", "/api/posts?limit=1&cursor="+cursor, "")
+			var body struct {
+				Posts []struct {
+					ID string `json:"id"`
+				} `json:"posts"`
+				NextCursor string `json:"next_cursor"`
+			}
+			json.NewDecoder(rec.Body).Decode(&body)
+			for _, p := range body.Posts {
+				ids = append(ids, p.ID)
+			}
+			if body.NextCursor == "" {
+				break
+			}
+			cursor = body.NextCursor
+		}
+		return strings.Join(ids, " ")
+	}
+	// The pinned post leads the feed across pages; the hidden one is only shown to moderators
+	if got := feed(alice); got != "p1 p3" {
+		t.Errorf("user feed = %s, want p1 p3", got)
+	}
+	if got := feed(mod); got != "p1 p3 p2" {
+		t.Errorf("moderator feed = %s, want p1 p3 p2", got)
+	}
+
+	details := GetPostWithComments(dbConn)
+	if rec := doRequest(t, details, alice, "GET", "/api/post-details?id=p2", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("user viewing hidden post: status %d, want 404", rec.Code)
+	}
+	if rec := doRequest(t, details, mod, "GET", "/api/post-details?id=p2", ""); !strings.Contains(rec.Body.String(), `"hidden_reason":"spam"`) {
+		t.Errorf("moderator view of hidden post lacks its reason: %s", rec.Body)
+	}
+
+	comments := CommentsHandler(dbConn)
+	if rec := doRequest(t, comments, alice, "POST", "/api/comments", `{"post_id":"p1","body":"hi"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("comment on locked post: status %d, want 403", rec.Code)
+	}
+	if rec := doRequest(t, comments, alice, "POST", "/api/comments", `{"post_id":"p2","body":"hi"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("comment on hidden post: status %d, want 404", rec.Code)
+	}
+	postComment(t, comments, mod, "p1", "", "Locked, see the rules")
+
+	logHandler := RequireRole(dbConn, RoleAdmin, ModerationLogHandler(dbConn))
+	if rec := doRequest(t, logHandler, mod, "GET", "/api/admin/moderation-log", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("moderator reading the log: status %d, want 403", rec.Code)
+	}
+	rec := doRequest(t, logHandler, admin, "GET", "/api/admin/moderation-log?moderator=mod&target_id=p2", "")
+	var entries []struct {
+		ModeratorNickname string `json:"moderator_nickname"`
+		Action            string `json:"action"`
+		Reason            string `json:"reason"`
+		CreatedAt         string `json:"created_at"`
+	}
+	json.NewDecoder(rec.Body).Decode(&entries)
+	if len(entries) != 1 || entries[0].ModeratorNickname != "mod" || entries[0].Action != "hide" ||
+		entries[0].Reason != "spam" || entries[0].CreatedAt == "" {
+		t.Errorf("log entries for p2 = %+v, want one hide by mod with its reason", entries)
+	}
+}
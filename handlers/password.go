@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"real-time-forum/mailer"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordResetTTL is how long a password reset link stays valid
+var PasswordResetTTL = time.Hour
+
+// BaseURL is the public address of the forum, used to build links sent by email
+var BaseURL = "http://localhost:8080"
+
+// newToken returns a random URL-safe token and the SHA-256 hex digest stored in its place
+func newToken() (token, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ForgotPasswordHandler emails a single-use reset link to the account with the
+// given email. It answers the same way whether or not the account exists so it
+// can't be used to discover registered addresses. Requests are throttled per
+// address and per client IP by limiter.
+func ForgotPasswordHandler(db *sql.DB, mail mailer.Mailer, limiter *LoginLimiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		email := strings.TrimSpace(r.FormValue("email"))
+		if email == "" {
+			http.Error(w, "Email required", http.StatusBadRequest)
+			return
+		}
+
+		// Count the request before looking the address up, so unknown
+		// addresses are throttled exactly like registered ones
+		account, ip := strings.ToLower(email), clientIP(r)
+		if wait := limiter.RetryAfter(account, ip); wait > 0 {
+			writeTooManyEmails(w, wait)
+			return
+		}
+		limiter.RecordFailure(account, ip)
+
+		var userID, nickname string
+		err := db.QueryRow(`SELECT id, nickname FROM users WHERE email = ?`, email).Scan(&userID, &nickname)
+		if err == nil {
+			if err := sendPasswordReset(db, mail, userID, nickname, email); err != nil {
+				log.Printf("Error sending password reset: %v", err)
+			}
+		} else if err != sql.ErrNoRows {
+			log.Printf("Database error: %v", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Fprintln(w, "If that email is registered, a reset link has been sent")
+	}
+}
+
+// sendPasswordReset replaces any outstanding reset token for the user with a new one and mails it
+func sendPasswordReset(db *sql.DB, mail mailer.Mailer, userID, nickname, email string) error {
+	token, hash, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM password_resets WHERE user_id = ? AND used_at IS NULL`, userID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO password_resets (token_hash, user_id, expires_at, created_at)
+		VALUES (?, ?, ?, ?)`,
+		hash, userID, time.Now().Add(PasswordResetTTL), time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	link := BaseURL + "/#reset-password/" + url.PathEscape(token)
+	body := fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password for your forum account. "+
+		"Open this link within %s to choose a new one:\n\n%s\n\n"+
+		"If it wasn't you, you can ignore this email.\n", nickname, PasswordResetTTL, link)
+	return mail.Send(email, "Reset your forum password", body)
+}
+
+// ResetPasswordHandler consumes a reset token, sets the new password and signs
+// the user out everywhere by deleting all of their sessions
+func ResetPasswordHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		token := r.FormValue("token")
+		password := r.FormValue("password")
+		confirmPassword := r.FormValue("confirmPassword")
+		if token == "" {
+			http.Error(w, "Reset token required", http.StatusBadRequest)
+			return
+		}
+		if err := validatePassword(password, confirmPassword); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		err = resetPassword(db, hashToken(token), string(hashedPassword))
+		if err == sql.ErrNoRows {
+			http.Error(w, "Reset link is invalid or has expired", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Printf("Error resetting password: %v", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		// The caller's own cookie, if any, now points at a deleted session
+		ClearSession(db, w, r)
+		fmt.Fprintln(w, "Password updated")
+	}
+}
+
+// resetPassword marks the token used, stores the new hash and revokes every
+// session of the user in one transaction. Claiming the token with a conditional
+// UPDATE makes it single-use even under concurrent requests. Returns
+// sql.ErrNoRows for unknown, used or expired tokens.
+func resetPassword(db *sql.DB, tokenHash, passwordHash string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	res, err := tx.Exec(`
+		UPDATE password_resets SET used_at = ?
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?`,
+		now, tokenHash, now,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	var userID string
+	if err := tx.QueryRow(`SELECT user_id FROM password_resets WHERE token_hash = ?`, tokenHash).Scan(&userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE users SET password_hash = ? WHERE id = ?`, passwordHash, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// recordingMailer keeps sent mail in memory
+type recordingMailer struct {
+	to, bodies []string
+}
+
+func (m *recordingMailer) Send(to, subject, body string) error {
+	m.to = append(m.to, to)
+	m.bodies = append(m.bodies, body)
+	return nil
+}
+
+// postForm runs handler against a form-encoded POST
+func postForm(handler http.HandlerFunc, values url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestPasswordResetIsSingleUseAndRevokesSessions(t *testing.T) {
+	dbConn := newTestDB(t)
+	newTestUser(t, dbConn, "alice-id", "alice")
+	mail := &recordingMailer{}
+	forgot := ForgotPasswordHandler(dbConn, mail, NewEmailLimiter(nil))
+
+	// Unknown addresses get the same answer and no mail
+	if rec := postForm(forgot, url.Values{"email": {"nobody@example.com"}}); rec.Code != http.StatusOK {
+		t.Errorf("unknown email: status %d, want 200", rec.Code)
+	}
+	if rec := postForm(forgot, url.Values{"email": {"alice@example.com"}}); rec.Code != http.StatusOK {
+		t.Fatalf("forgot: status %d: %s", rec.Code, rec.Body)
+	}
+	if len(mail.to) != 1 || mail.to[0] != "alice@example.com" {
+		t.Fatalf("mail sent to %v, want only alice", mail.to)
+	}
+	match := regexp.MustCompile(`#reset-password/([A-Za-z0-9_-]+)`).FindStringSubmatch(mail.bodies[0])
+	if match == nil {
+		t.Fatalf("no token in mail body: %s", mail.bodies[0])
+	}
+	token := match[1]
+
+	var stored int
+	dbConn.QueryRow(`SELECT COUNT(*) FROM password_resets WHERE token_hash = ?`, token).Scan(&stored)
+	if stored != 0 {
+		t.Error("raw token stored in the database")
+	}
+
+	reset := url.Values{"token": {token}, "password": {"n3w-secret"}, "confirmPassword": {"n3w-secret"}}
+	if rec := postForm(ResetPasswordHandler(dbConn), reset); rec.Code != http.StatusOK {
+		t.Fatalf("reset: status %d: %s", rec.Code, rec.Body)
+	}
+
+	var hash string
+	var sessions int
+	dbConn.QueryRow(`SELECT password_hash FROM users WHERE id = 'alice-id'`).Scan(&hash)
+	dbConn.QueryRow(`SELECT COUNT(*) FROM sessions WHERE user_id = 'alice-id'`).Scan(&sessions)
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte("n3w-secret")) != nil {
+		t.Error("password hash was not rotated")
+	}
+	if sessions != 0 {
+		t.Errorf("%d sessions left, want all revoked", sessions)
+	}
+
+	if rec := postForm(ResetPasswordHandler(dbConn), reset); rec.Code != http.StatusBadRequest {
+		t.Errorf("reusing token: status %d, want 400", rec.Code)
+	}
+}
+
+func TestForgotPasswordIsThrottledPerAddressAndIP(t *testing.T) {
+	dbConn := newTestDB(t)
+	newTestUser(t, dbConn, "alice-id", "alice")
+	mail := &recordingMailer{}
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewEmailLimiter(func() time.Time { return now })
+	limiter.IP.MaxFailures = 3
+	forgot := ForgotPasswordHandler(dbConn, mail, limiter)
+
+	if rec := postForm(forgot, url.Values{"email": {"alice@example.com"}}); rec.Code != http.StatusOK {
+		t.Fatalf("first request: status %d: %s", rec.Code, rec.Body)
+	}
+	rec := postForm(forgot, url.Values{"email": {"ALICE@example.com"}})
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Errorf("repeat request: status %d, Retry-After %q, want 429 and 60", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if len(mail.to) != 1 {
+		t.Errorf("sent %d emails, want 1", len(mail.to))
+	}
+
+	// Unknown addresses are throttled the same way, so a 429 reveals nothing
+	postForm(forgot, url.Values{"email": {"nobody@example.com"}})
+	if rec := postForm(forgot, url.Values{"email": {"nobody@example.com"}}); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("repeat request for unknown address: status %d, want 429", rec.Code)
+	}
+
+	// The IP has now made its three requests, whatever the address
+	if rec := postForm(forgot, url.Values{"email": {"other@example.com"}}); rec.Code != http.StatusOK {
+		t.Errorf("third address: status %d, want 200", rec.Code)
+	}
+	if rec := postForm(forgot, url.Values{"email": {"fourth@example.com"}}); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("fourth address from the same IP: status %d, want 429", rec.Code)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if rec := postForm(forgot, url.Values{"email": {"alice@example.com"}}); rec.Code != http.StatusOK {
+		t.Errorf("after the lockout: status %d, want 200", rec.Code)
+	}
+	if len(mail.to) != 2 {
+		t.Errorf("sent %d emails, want 2", len(mail.to))
+	}
+}
@@ -2,15 +2,19 @@ package handlers
 
 import (
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"log"
+	"math"
 	"net/http"
 	"real-time-forum/models"
+	"strconv"
 	"time"
 
 	"github.com/gofrs/uuid"
 )
 
-// PostsHandler handles both GET and POST for posts
+// PostsHandler handles listing, creating, editing and deleting posts
 func PostsHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Check session first
@@ -25,6 +29,10 @@ func PostsHandler(db *sql.DB) http.HandlerFunc {
 			handleGetPosts(db, w, r, session)
 		case "POST":
 			handleCreatePost(db, w, r, session)
+		case "PUT", "PATCH":
+			handleUpdatePost(db, w, r, session)
+		case "DELETE":
+			handleDeletePost(db, w, r, session)
 		default:
 			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		}
@@ -54,15 +62,11 @@ func GetPostWithComments(db *sql.DB) http.HandlerFunc {
 		}
 
 		// First, get the post
-		var post models.Post
-		err := db.QueryRow(`
-			SELECT id, user_id, category_id, title, content, likes, dislikes, created_at 
-			FROM posts 
-			WHERE id = ?
-		`, postID).Scan(
-			&post.ID, &post.UserID, &post.CategoryID, &post.Title, &post.Content,
-			&post.LikeCount, &post.DislikeCount, &post.CreatedAt,
-		)
+		post, err := scanPost(db.QueryRow(`
+			SELECT `+postColumns+`
+			FROM posts p `+postJoins+`
+			WHERE p.id = ? AND p.deleted_at IS NULL`+hiddenPostFilter(session)+`
+		`, session.UserID, postID))
 
 		if err != nil {
 			if err == sql.ErrNoRows {
@@ -75,7 +79,8 @@ func GetPostWithComments(db *sql.DB) http.HandlerFunc {
 
 		// Then, get all comments for this post with user nicknames
 		rows, err := db.Query(`
-			SELECT c.id, c.post_id, c.user_id, c.nickname, c.content, c.created_at 
+			SELECT c.id, c.post_id, c.user_id, c.nickname, c.content, c.created_at, c.parent_id,
+				c.edited_at, c.deleted_at IS NOT NULL
 			FROM comments c
 			WHERE c.post_id = ? 
 			ORDER BY c.created_at ASC
@@ -91,21 +96,25 @@ func GetPostWithComments(db *sql.DB) http.HandlerFunc {
 		var comments []models.Comment
 		for rows.Next() {
 			var c models.Comment
-			err := rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.Nickname, &c.Content, &c.CreatedAt)
+			err := rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.Nickname, &c.Content, &c.CreatedAt, &c.ParentID,
+				&c.EditedAt, &c.Deleted)
 			if err != nil {
 				http.Error(w, "Error scanning comment", http.StatusInternalServerError)
 				return
 			}
+			if c.Deleted {
+				tombstoneComment(&c)
+			}
 			comments = append(comments, c)
 		}
 
-		// Combine post and comments in one response
+		// Combine post and comments in one response, with replies listed under their parents
 		response := struct {
 			Post     models.Post      `json:"post"`
 			Comments []models.Comment `json:"comments"`
 		}{
 			Post:     post,
-			Comments: comments,
+			Comments: threadComments(comments),
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -113,39 +122,181 @@ func GetPostWithComments(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-// handleGetPosts gets posts with optional category filter
+// commentCountExpr counts the live comments on post p
+const commentCountExpr = `(SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id AND c.deleted_at IS NULL)`
+
+// postColumns is the select list shared by every post query, read back by scanPost.
+// Last activity is the latest of creation, edit and newest live comment, compared
+// as Julian days because Go and SQLite write timestamps in different formats.
+const postColumns = `p.id, p.user_id, COALESCE(u.nickname, ''), p.category_id, p.title, p.content,
+	p.likes, p.dislikes, p.created_at, p.edited_at, COALESCE(v.value, 0), ` + commentCountExpr + `,
+	MAX(julianday(p.created_at), COALESCE(julianday(p.edited_at), 0),
+		COALESCE((SELECT MAX(julianday(c.created_at)) FROM comments c WHERE c.post_id = p.id AND c.deleted_at IS NULL), 0)),
+	p.pinned_at IS NOT NULL AS pinned, p.locked_at IS NOT NULL, p.hidden_at IS NOT NULL, COALESCE(p.hidden_reason, '')`
+
+// postJoins adds the author and the caller's vote to posts p; its ? is the caller's user ID
+const postJoins = `
+	LEFT JOIN users u ON u.id = p.user_id
+	LEFT JOIN post_votes v ON v.post_id = p.id AND v.user_id = ?`
+
+// scanPost reads a row selected with postColumns, followed by any extra columns into extra
+func scanPost(row interface{ Scan(...interface{}) error }, extra ...interface{}) (models.Post, error) {
+	var p models.Post
+	var userVote int
+	var lastActivity float64
+	dest := append([]interface{}{
+		&p.ID, &p.UserID, &p.AuthorNickname, &p.CategoryID, &p.Title, &p.Content,
+		&p.LikeCount, &p.DislikeCount, &p.CreatedAt, &p.EditedAt, &userVote, &p.CommentCount, &lastActivity,
+		&p.Pinned, &p.Locked, &p.Hidden, &p.HiddenReason,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return p, err
+	}
+	p.UserVote = voteName(userVote)
+	p.LastActivityAt = fromJulianDay(lastActivity)
+	return p, nil
+}
+
+// postSortKeys maps each feed sort mode to the numeric key posts are ordered by.
+// Ties are broken on id, so (sort_key, id) is a unique keyset cursor. "hot"
+// divides the net score by the squared age in hours, evaluated against the
+// time the first page was requested (the ? placeholders) so pages stay stable.
+var postSortKeys = map[string]string{
+	"newest":         `julianday(p.created_at)`,
+	"oldest":         `julianday(p.created_at)`,
+	"most_liked":     `p.likes`,
+	"most_commented": commentCountExpr,
+	"hot": `CAST(p.likes - p.dislikes + ` + commentCountExpr + ` + 1 AS REAL) /
+		((MAX(? - julianday(p.created_at), 0) * 24 + 2) * (MAX(? - julianday(p.created_at), 0) * 24 + 2))`,
+}
+
+// postCursor is the position after the last post of a page, sent to clients as an opaque string
+type postCursor struct {
+	Sort   string  `json:"s"`
+	Pinned bool    `json:"p,omitempty"` // still within the pinned posts at the top
+	Key    float64 `json:"k"`
+	ID     string  `json:"id"`
+	Now    float64 `json:"n,omitempty"` // reference time for "hot", as a Julian day
+}
+
+func (c postCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodePostCursor(s string) (postCursor, error) {
+	var c postCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
+
+// julianDay converts t to the Julian day number SQLite's julianday() returns
+func julianDay(t time.Time) float64 {
+	return float64(t.UnixNano())/float64(24*time.Hour) + 2440587.5
+}
+
+// fromJulianDay is the inverse of julianDay, rounded to the millisecond
+func fromJulianDay(jd float64) time.Time {
+	ms := math.Round((jd - 2440587.5) * float64(24*time.Hour/time.Millisecond))
+	return time.UnixMilli(int64(ms)).UTC()
+}
+
+// handleGetPosts returns one page of the feed with an optional category filter.
+// Pinned posts come first, each group in the requested order. Hidden posts are
+// only included for moderators.
+//
+// Query parameters: category, sort (newest, oldest, most_liked, most_commented
+// or hot), limit, and cursor (the next_cursor of the previous page).
 func handleGetPosts(db *sql.DB, w http.ResponseWriter, r *http.Request, session *models.Session) {
 	category := r.URL.Query().Get("category")
 
-	var rows *sql.Rows
-	var err error
+	sortMode := r.URL.Query().Get("sort")
+	if sortMode == "" {
+		sortMode = "newest"
+	}
+	sortKey, ok := postSortKeys[sortMode]
+	if !ok {
+		http.Error(w, "Unknown sort mode", http.StatusBadRequest)
+		return
+	}
+
+	// Pagination parameters
+	limit := 20 // default
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if n, err := strconv.Atoi(l); err == nil && n > 0 && n <= 50 {
+			limit = n
+		}
+	}
 
+	var cursor *postCursor
+	if c := r.URL.Query().Get("cursor"); c != "" {
+		decoded, err := decodePostCursor(c)
+		if err != nil || decoded.Sort != sortMode {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		cursor = &decoded
+	}
+
+	now := julianDay(time.Now())
+	if cursor != nil && cursor.Now != 0 {
+		now = cursor.Now
+	}
+
+	var args []interface{}
+	if sortMode == "hot" {
+		args = append(args, now, now)
+	}
+
+	query := `
+        SELECT * FROM (
+            SELECT ` + postColumns + `, ` + sortKey + ` AS sort_key
+            FROM posts p ` + postJoins + `
+            WHERE p.deleted_at IS NULL` + hiddenPostFilter(session)
+	args = append(args, session.UserID)
 	if category != "" && category != "all" {
-		// FIXED: Added missing backtick and fixed query syntax
-		rows, err = db.Query(`
-            SELECT id, user_id, category_id, title, content, likes, dislikes, created_at 
-            FROM posts 
-            WHERE category_id = ? 
-            ORDER BY created_at DESC`,
-			category)
-	} else {
-		// FIXED: Added missing backtick
-		rows, err = db.Query(`
-            SELECT id, user_id, category_id, title, content, likes, dislikes, created_at 
-            FROM posts 
-            ORDER BY created_at DESC`)
+		query += " AND p.category_id = ?"
+		args = append(args, category)
+	}
+	query += "\n        )"
+
+	order, after := "DESC", "<"
+	if sortMode == "oldest" {
+		order, after = "ASC", ">"
 	}
+	if cursor != nil {
+		// Past the cursor within its group, and every unpinned post once past the pinned ones
+		query += " WHERE (pinned = ? AND (sort_key " + after + " ? OR (sort_key = ? AND id " + after + " ?)))"
+		args = append(args, cursor.Pinned, cursor.Key, cursor.Key, cursor.ID)
+		if cursor.Pinned {
+			query += " OR pinned = 0"
+		}
+	}
+	// One extra row tells us whether there is another page
+	query += " ORDER BY pinned DESC, sort_key " + order + ", id " + order + " LIMIT ?"
+	args = append(args, limit+1)
 
+	rows, err := db.Query(query, args...)
 	if err != nil {
+		log.Printf("Database error loading posts: %v", err)
 		http.Error(w, "Failed to fetch posts", http.StatusInternalServerError)
 		return
 	}
 	defer rows.Close()
 
-	var posts []models.Post
+	posts := []models.Post{}
+	var lastKey float64
+	hasMore := false
 	for rows.Next() {
-		var p models.Post
-		err := rows.Scan(&p.ID, &p.UserID, &p.CategoryID, &p.Title, &p.Content, &p.LikeCount, &p.DislikeCount, &p.CreatedAt)
+		if len(posts) == limit {
+			hasMore = true
+			break
+		}
+		p, err := scanPost(rows, &lastKey)
 		if err != nil {
 			http.Error(w, "Error scanning post", http.StatusInternalServerError)
 			return
@@ -153,12 +304,30 @@ func handleGetPosts(db *sql.DB, w http.ResponseWriter, r *http.Request, session
 		posts = append(posts, p)
 	}
 
+	// next_cursor is empty on the last page
+	var nextCursor string
+	if hasMore {
+		last := posts[len(posts)-1]
+		next := postCursor{Sort: sortMode, Pinned: last.Pinned, Key: lastKey, ID: last.ID}
+		if sortMode == "hot" {
+			next.Now = now
+		}
+		nextCursor = next.encode()
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(posts)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"posts":       posts,
+		"next_cursor": nextCursor,
+	})
 }
 
 // handleCreatePost creates a new post
 func handleCreatePost(db *sql.DB, w http.ResponseWriter, r *http.Request, session *models.Session) {
+	if !requireVerified(w, session, ActionPost) {
+		return
+	}
+
 	var post models.Post
 	err := json.NewDecoder(r.Body).Decode(&post)
 	if err != nil {
@@ -186,6 +355,13 @@ func handleCreatePost(db *sql.DB, w http.ResponseWriter, r *http.Request, sessio
 	if post.CategoryID == "" {
 		post.CategoryID = "general"
 	}
+	if ok, err := categoryAcceptsPosts(db, post.CategoryID); err != nil {
+		http.Error(w, "Failed to check category", http.StatusInternalServerError)
+		return
+	} else if !ok {
+		http.Error(w, "Unknown category", http.StatusBadRequest)
+		return
+	}
 
 	// FIXED: SQL syntax errors - removed extra comma and fixed query structure
 	_, err = db.Exec(`
@@ -201,3 +377,127 @@ func handleCreatePost(db *sql.DB, w http.ResponseWriter, r *http.Request, sessio
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(post)
 }
+
+// handleUpdatePost edits the title, content or category of one of the caller's posts.
+// PUT replaces title and content, PATCH changes only the fields that are sent.
+func handleUpdatePost(db *sql.DB, w http.ResponseWriter, r *http.Request, session *models.Session) {
+	if !requireVerified(w, session, ActionPost) {
+		return
+	}
+
+	postID := r.URL.Query().Get("id")
+	if postID == "" {
+		http.Error(w, "Post ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var requestData struct {
+		Title      *string `json:"title"`
+		Content    *string `json:"content"`
+		CategoryID *string `json:"category_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		http.Error(w, "Invalid post data", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == "PUT" && (requestData.Title == nil || requestData.Content == nil) {
+		http.Error(w, "Title and content are required", http.StatusBadRequest)
+		return
+	}
+	if (requestData.Title != nil && *requestData.Title == "") || (requestData.Content != nil && *requestData.Content == "") {
+		http.Error(w, "Title and content are required", http.StatusBadRequest)
+		return
+	}
+
+	if !authorizePostAuthor(db, w, postID, session) {
+		return
+	}
+
+	if requestData.CategoryID != nil && *requestData.CategoryID != "" {
+		if ok, err := categoryAcceptsPosts(db, *requestData.CategoryID); err != nil {
+			http.Error(w, "Failed to check category", http.StatusInternalServerError)
+			return
+		} else if !ok {
+			http.Error(w, "Unknown category", http.StatusBadRequest)
+			return
+		}
+	}
+
+	editedAt := time.Now()
+	_, err := db.Exec(`
+		UPDATE posts SET
+			title = COALESCE(?, title),
+			content = COALESCE(?, content),
+			category_id = COALESCE(NULLIF(?, ''), category_id),
+			edited_at = ?
+		WHERE id = ? AND deleted_at IS NULL`,
+		requestData.Title, requestData.Content, requestData.CategoryID, editedAt, postID,
+	)
+	if err != nil {
+		http.Error(w, "Failed to update post", http.StatusInternalServerError)
+		return
+	}
+
+	post, err := scanPost(db.QueryRow(`
+		SELECT `+postColumns+`
+		FROM posts p `+postJoins+`
+		WHERE p.id = ?
+	`, session.UserID, postID))
+	if err != nil {
+		http.Error(w, "Failed to fetch post", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(post)
+}
+
+// handleDeletePost soft-deletes one of the caller's posts. The row is kept so
+// comments and votes referencing it stay valid; it just stops being listed.
+func handleDeletePost(db *sql.DB, w http.ResponseWriter, r *http.Request, session *models.Session) {
+	postID := r.URL.Query().Get("id")
+	if postID == "" {
+		http.Error(w, "Post ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !authorizePostAuthor(db, w, postID, session) {
+		return
+	}
+
+	deletedAt := time.Now()
+	_, err := db.Exec(`UPDATE posts SET deleted_at = ?, edited_at = ? WHERE id = ? AND deleted_at IS NULL`,
+		deletedAt, deletedAt, postID)
+	if err != nil {
+		http.Error(w, "Failed to delete post", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":         postID,
+		"deleted":    true,
+		"edited_at":  deletedAt,
+		"deleted_at": deletedAt,
+	})
+}
+
+// authorizePostAuthor checks that the post exists, is not deleted and belongs to
+// the caller, writing the error response and returning false otherwise
+func authorizePostAuthor(db *sql.DB, w http.ResponseWriter, postID string, session *models.Session) bool {
+	var authorID string
+	err := db.QueryRow(`SELECT user_id FROM posts WHERE id = ? AND deleted_at IS NULL`, postID).Scan(&authorID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return false
+	} else if err != nil {
+		http.Error(w, "Failed to fetch post", http.StatusInternalServerError)
+		return false
+	}
+	if authorID != session.UserID {
+		http.Error(w, "You can only modify your own posts", http.StatusForbidden)
+		return false
+	}
+	return true
+}
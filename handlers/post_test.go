@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// doRequest runs handler against a request carrying the session cookie
+func doRequest(t *testing.T, handler http.HandlerFunc, cookie *http.Cookie, method, url, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestPostEditAndDeleteAreAuthorOnly(t *testing.T) {
+	dbConn := newTestDB(t)
+	alice := newTestUser(t, dbConn, "alice-id", "alice")
+	bob := newTestUser(t, dbConn, "bob-id", "bob")
+	handler := PostsHandler(dbConn)
+
+	rec := doRequest(t, handler, alice, "POST", "/api/posts", `{"title":"Helo","content":"first"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", rec.Code, rec.Body)
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	json.NewDecoder(rec.Body).Decode(&created)
+	url := "/api/posts?id=" + created.ID
+
+	if rec := doRequest(t, handler, bob, "PATCH", url, `{"title":"Hijacked"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("bob edit: status %d, want 403", rec.Code)
+	}
+	if rec := doRequest(t, handler, bob, "DELETE", url, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("bob delete: status %d, want 403", rec.Code)
+	}
+
+	rec = doRequest(t, handler, alice, "PATCH", url, `{"title":"Hello"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("alice edit: status %d: %s", rec.Code, rec.Body)
+	}
+	var edited map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&edited)
+	if edited["title"] != "Hello" || edited["content"] != "first" || edited["edited_at"] == nil {
+		t.Errorf("edited post = %v, want new title, old content and edited_at", edited)
+	}
+
+	if rec := doRequest(t, handler, alice, "DELETE", url, ""); rec.Code != http.StatusOK {
+		t.Fatalf("alice delete: status %d: %s", rec.Code, rec.Body)
+	}
+
+	// The row survives for foreign keys but is no longer listed or editable
+	var rows int
+	dbConn.QueryRow(`SELECT COUNT(*) FROM posts WHERE id = ?`, created.ID).Scan(&rows)
+	if rows != 1 {
+		t.Errorf("post row count = %d, want 1 after soft delete", rows)
+	}
+	rec = doRequest(t, handler, alice, "GET", "/api/posts", "")
+	var feed struct {
+		Posts []map[string]interface{} `json:"posts"`
+	}
+	json.NewDecoder(rec.Body).Decode(&feed)
+	if len(feed.Posts) != 0 {
+		t.Errorf("listing after delete = %v, want no posts", feed.Posts)
+	}
+	if rec := doRequest(t, handler, alice, "PATCH", url, `{"title":"Back"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("edit after delete: status %d, want 404", rec.Code)
+	}
+}
+
+func TestPostFeedCursorPagination(t *testing.T) {
+	dbConn := newTestDB(t)
+	alice := newTestUser(t, dbConn, "alice-id", "alice")
+	handler := PostsHandler(dbConn)
+
+	// Five posts an hour apart; p3 and p4 share a timestamp to exercise the id tiebreak
+	_, err := dbConn.Exec(`
+		INSERT INTO posts (id, user_id, title, content, likes, created_at) VALUES
+			('p1', 'alice-id', 't', 'c', 5, '2026-01-01 10:00:00'),
+			('p2', 'alice-id', 't', 'c', 1, '2026-01-01 11:00:00'),
+			('p3', 'alice-id', 't', 'c', 5, '2026-01-01 12:00:00'),
+			('p4', 'alice-id', 't', 'c', 0, '2026-01-01 12:00:00'),
+			('p5', 'alice-id', 't', 'c', 2, '2026-01-01 14:00:00');
+		INSERT INTO comments (id, post_id, user_id, nickname, content) VALUES
+			('c1', 'p2', 'alice-id', 'alice', 'x'), ('c2', 'p2', 'alice-id', 'alice', 'y');`)
+	if err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	walk := func(sort string) []string {
+		t.Helper()
+		var ids []string
+		cursor := ""
+		for page := 0; page < 10; page++ {
+			rec := doRequest(t, handler, alice, "GET", "/api/posts?limit=2&sort="+sort+"&cursor="+cursor, "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s page %d: status %d: %s", sort, page, rec.Code, rec.Body)
+			}
+			var feed struct {
+				Posts []struct {
+					ID string `json:"id"`
+				} `json:"posts"`
+				NextCursor string `json:"next_cursor"`
+			}
+			json.NewDecoder(rec.Body).Decode(&feed)
+			for _, p := range feed.Posts {
+				ids = append(ids, p.ID)
+			}
+			if feed.NextCursor == "" {
+				return ids
+			}
+			cursor = feed.NextCursor
+		}
+		t.Fatalf("%s: pagination did not terminate", sort)
+		return nil
+	}
+
+	for sort, want := range map[string]string{
+		"newest":         "p5 p4 p3 p2 p1",
+		"oldest":         "p1 p2 p3 p4 p5",
+		"most_liked":     "p3 p1 p5 p2 p4",
+		"most_commented": "p2 p5 p4 p3 p1",
+	} {
+		if got := strings.Join(walk(sort), " "); got != want {
+			t.Errorf("sort=%s: got %s, want %s", sort, got, want)
+		}
+	}
+	if got := walk("hot"); len(got) != 5 {
+		t.Errorf("sort=hot returned %v, want all five posts once", got)
+	}
+
+	// Every post in the list carries its author and comment stats
+	rec := doRequest(t, handler, alice, "GET", "/api/posts?sort=most_commented&limit=1", "")
+	var feed struct {
+		Posts []struct {
+			AuthorNickname string    `json:"author_nickname"`
+			CommentCount   int       `json:"comment_count"`
+			CreatedAt      time.Time `json:"created_at"`
+			LastActivityAt time.Time `json:"last_activity_at"`
+		} `json:"posts"`
+	}
+	json.NewDecoder(rec.Body).Decode(&feed)
+	if len(feed.Posts) != 1 || feed.Posts[0].AuthorNickname != "alice" || feed.Posts[0].CommentCount != 2 {
+		t.Errorf("top post = %+v, want alice's post with 2 comments", feed.Posts)
+	} else if !feed.Posts[0].LastActivityAt.After(feed.Posts[0].CreatedAt) {
+		t.Errorf("last activity %v is not after creation %v", feed.Posts[0].LastActivityAt, feed.Posts[0].CreatedAt)
+	}
+
+	if rec := doRequest(t, handler, alice, "GET", "/api/posts?sort=oldest&cursor=bogus", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad cursor: status %d, want 400", rec.Code)
+	}
+}
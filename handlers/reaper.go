@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ReaperStats counts what the reaper has cleaned up since the server started
+type ReaperStats struct {
+	Runs               int64     `json:"runs"`
+	LastRun            time.Time `json:"last_run"`
+	LastError          string    `json:"last_error,omitempty"`
+	Sessions           int64     `json:"sessions"`
+	PasswordResets     int64     `json:"password_resets"`
+	EmailVerifications int64     `json:"email_verifications"`
+	PendingLogins      int64     `json:"pending_logins"`
+	WebSockets         int64     `json:"websockets"`
+}
+
+// Reaper periodically deletes expired sessions and spent or expired tokens,
+// and disconnects WebSockets whose session is gone
+type Reaper struct {
+	Interval time.Duration
+
+	db          *sql.DB
+	connManager *ConnectionManager
+	now         func() time.Time
+
+	mutex sync.Mutex
+	stats ReaperStats
+}
+
+// NewReaper creates a reaper that runs every interval once started with Run
+func NewReaper(db *sql.DB, connManager *ConnectionManager, interval time.Duration) *Reaper {
+	return &Reaper{
+		Interval:    interval,
+		db:          db,
+		connManager: connManager,
+		now:         time.Now,
+	}
+}
+
+// Run sweeps immediately and then every Interval until ctx is cancelled
+func (rp *Reaper) Run(ctx context.Context) {
+	ticker := time.NewTicker(rp.Interval)
+	defer ticker.Stop()
+
+	for {
+		swept, err := rp.Sweep()
+		if err != nil {
+			log.Printf("Reaper error: %v", err)
+		} else if swept.Sessions+swept.PasswordResets+swept.EmailVerifications+swept.PendingLogins+swept.WebSockets > 0 {
+			log.Printf("Reaper removed %d sessions, %d password resets, %d email verifications, %d pending logins and closed %d WebSockets",
+				swept.Sessions, swept.PasswordResets, swept.EmailVerifications, swept.PendingLogins, swept.WebSockets)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("Reaper stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep runs one cleanup pass and returns what it removed. The totals are
+// added to Stats even when a later step fails.
+func (rp *Reaper) Sweep() (ReaperStats, error) {
+	now := rp.now()
+	swept := ReaperStats{Runs: 1, LastRun: now}
+
+	steps := []struct {
+		count *int64
+		query string
+	}{
+		{&swept.Sessions, `DELETE FROM sessions WHERE expires_at <= ?`},
+		{&swept.PasswordResets, `DELETE FROM password_resets WHERE expires_at <= ? OR used_at IS NOT NULL`},
+		{&swept.EmailVerifications, `DELETE FROM email_verifications WHERE expires_at <= ?`},
+		{&swept.PendingLogins, `DELETE FROM pending_logins WHERE expires_at <= ?`},
+	}
+	var err error
+	for _, step := range steps {
+		var res sql.Result
+		if res, err = rp.db.Exec(step.query, now); err != nil {
+			break
+		}
+		*step.count, _ = res.RowsAffected()
+	}
+	if err == nil {
+		swept.WebSockets, err = rp.closeExpiredWebSockets(now)
+	}
+	if err != nil {
+		swept.LastError = err.Error()
+	}
+
+	rp.mutex.Lock()
+	rp.stats.Runs++
+	rp.stats.LastRun = now
+	rp.stats.LastError = swept.LastError
+	rp.stats.Sessions += swept.Sessions
+	rp.stats.PasswordResets += swept.PasswordResets
+	rp.stats.EmailVerifications += swept.EmailVerifications
+	rp.stats.PendingLogins += swept.PendingLogins
+	rp.stats.WebSockets += swept.WebSockets
+	rp.mutex.Unlock()
+
+	return swept, err
+}
+
+// closeExpiredWebSockets disconnects every connection whose session no longer
+// exists or has expired
+func (rp *Reaper) closeExpiredWebSockets(now time.Time) (int64, error) {
+	expired := map[string][]string{} // user ID -> session IDs
+	for userID, sessionIDs := range rp.connManager.sessionIDs() {
+		for _, sessionID := range sessionIDs {
+			var live bool
+			err := rp.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM sessions WHERE id = ? AND expires_at > ?)`, sessionID, now).Scan(&live)
+			if err != nil {
+				return 0, err
+			}
+			if !live {
+				expired[userID] = append(expired[userID], sessionID)
+			}
+		}
+	}
+
+	var closed int64
+	for userID, sessionIDs := range expired {
+		closed += int64(rp.connManager.CloseSessions(userID, sessionIDs...))
+	}
+	return closed, nil
+}
+
+// Stats returns the running totals
+func (rp *Reaper) Stats() ReaperStats {
+	rp.mutex.Lock()
+	defer rp.mutex.Unlock()
+	return rp.stats
+}
+
+// ReaperStatsHandler reports the reaper's totals to admins
+func ReaperStatsHandler(db *sql.DB, reaper *Reaper) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if requirePermission(db, w, r, PermViewMaintenance) == nil {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reaper.Stats())
+	}
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestReaperPurgesExpiredRowsAndClosesTheirWebSockets(t *testing.T) {
+	dbConn := newTestDB(t)
+	alice := newTestUser(t, dbConn, "alice-id", "alice")
+	bob := newTestUser(t, dbConn, "bob-id", "bob")
+
+	connManager := NewConnectionManager()
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	server := httptest.NewServer(HandleWebSocket(dbConn, connManager, upgrader))
+	t.Cleanup(server.Close)
+	aliceWS := dialWS(t, server, alice)
+	bobWS := dialWS(t, server, bob)
+	time.Sleep(50 * time.Millisecond)
+
+	past := time.Now().Add(-time.Hour)
+	dbConn.Exec(`UPDATE sessions SET expires_at = ? WHERE id = ?`, past, alice.Value)
+	dbConn.Exec(`INSERT INTO password_resets (token_hash, user_id, expires_at) VALUES ('old', 'bob-id', ?)`, past)
+	dbConn.Exec(`INSERT INTO password_resets (token_hash, user_id, expires_at) VALUES ('live', 'bob-id', ?)`, time.Now().Add(time.Hour))
+	dbConn.Exec(`INSERT INTO email_verifications (nonce, user_id, email, expires_at) VALUES ('n', 'bob-id', 'bob@example.com', ?)`, past)
+
+	reaper := NewReaper(dbConn, connManager, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		reaper.Run(ctx)
+		close(stopped)
+	}()
+
+	aliceWS.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := aliceWS.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Errorf("expired session's WebSocket ended with %v, want a normal close", err)
+			}
+			break
+		}
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("reaper did not stop after its context was cancelled")
+	}
+
+	stats := reaper.Stats()
+	want := ReaperStats{Runs: 1, Sessions: 1, PasswordResets: 1, EmailVerifications: 1, WebSockets: 1}
+	if stats.Runs != want.Runs || stats.Sessions != want.Sessions || stats.PasswordResets != want.PasswordResets ||
+		stats.EmailVerifications != want.EmailVerifications || stats.WebSockets != want.WebSockets {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+
+	var resets int
+	dbConn.QueryRow(`SELECT COUNT(*) FROM password_resets`).Scan(&resets)
+	if resets != 1 {
+		t.Errorf("%d password resets left, want the live one", resets)
+	}
+
+	// Bob's session is live, so his connection stays open
+	bobWS.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	for {
+		_, _, err := bobWS.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if netErr, ok := err.(interface{ Timeout() bool }); !ok || !netErr.Timeout() {
+			t.Errorf("live session's WebSocket closed: %v", err)
+		}
+		break
+	}
+}
@@ -0,0 +1,448 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"real-time-forum/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Report states. Open and claimed reports are pending; a reporter can only
+// have one pending report per target.
+const (
+	reportOpen      = "open"
+	reportClaimed   = "claimed"
+	reportResolved  = "resolved"
+	reportDismissed = "dismissed"
+)
+
+// reportCategories are the reasons a report can be filed under
+var reportCategories = map[string]bool{
+	"spam":           true,
+	"harassment":     true,
+	"hate":           true,
+	"sexual":         true,
+	"violence":       true,
+	"misinformation": true,
+	"other":          true,
+}
+
+const (
+	maxReportDetails = 1000
+	maxReportExcerpt = 280
+)
+
+var (
+	errUnknownReportTarget = errors.New("target_type must be post, comment, message or user")
+	errReportOwnContent    = errors.New("you can't report yourself or your own content")
+	errReportPostAction    = errors.New("post_action only applies to reports about posts, when resolving")
+	errReportedPostGone    = errors.New("the reported post no longer exists")
+)
+
+const reportColumns = `r.id, r.reporter_id, COALESCE(ru.nickname, ''), r.target_type, r.target_id, r.excerpt,
+	r.category, r.details, r.status, COALESCE(cu.nickname, ''), r.claimed_at, COALESCE(vu.nickname, ''),
+	r.resolved_at, r.resolution_note, r.moderation_log_id, r.created_at`
+
+const reportJoins = `
+	LEFT JOIN users ru ON ru.id = r.reporter_id
+	LEFT JOIN users cu ON cu.id = r.claimed_by
+	LEFT JOIN users vu ON vu.id = r.resolved_by`
+
+func scanReport(row interface{ Scan(...interface{}) error }) (models.Report, error) {
+	var rep models.Report
+	err := row.Scan(&rep.ID, &rep.ReporterID, &rep.ReporterNickname, &rep.TargetType, &rep.TargetID, &rep.Excerpt,
+		&rep.Category, &rep.Details, &rep.Status, &rep.ClaimedByNickname, &rep.ClaimedAt, &rep.ResolvedByNickname,
+		&rep.ResolvedAt, &rep.ResolutionNote, &rep.ModerationLogID, &rep.CreatedAt)
+	return rep, err
+}
+
+func loadReport(db *sql.DB, id int64) (models.Report, error) {
+	return scanReport(db.QueryRow(`SELECT `+reportColumns+` FROM reports r `+reportJoins+` WHERE r.id = ?`, id))
+}
+
+// reportTarget looks up what a report is about and returns an excerpt of it.
+// It returns sql.ErrNoRows if the target doesn't exist or the session can't
+// see it: deleted content, hidden posts for non-moderators, and chat messages
+// from conversations the reporter isn't part of.
+func reportTarget(db *sql.DB, session *models.Session, targetType, targetID string) (string, error) {
+	var ownerID, excerpt string
+	var err error
+	switch targetType {
+	case "post":
+		err = db.QueryRow(`
+			SELECT p.user_id, p.title FROM posts p
+			WHERE p.id = ? AND p.deleted_at IS NULL`+hiddenPostFilter(session), targetID,
+		).Scan(&ownerID, &excerpt)
+	case "comment":
+		err = db.QueryRow(`
+			SELECT c.user_id, c.content FROM comments c
+			JOIN posts p ON p.id = c.post_id
+			WHERE c.id = ? AND c.deleted_at IS NULL AND p.deleted_at IS NULL`+hiddenPostFilter(session), targetID,
+		).Scan(&ownerID, &excerpt)
+	case "message":
+		err = db.QueryRow(`
+			SELECT m.sender_id, m.content FROM messages m
+			JOIN chats ch ON ch.id = m.chat_id
+			WHERE m.id = ? AND ? IN (ch.user1_id, ch.user2_id)`, targetID, session.UserID,
+		).Scan(&ownerID, &excerpt)
+	case "user":
+		err = db.QueryRow(`SELECT id, nickname FROM users WHERE id = ?`, targetID).Scan(&ownerID, &excerpt)
+	default:
+		return "", errUnknownReportTarget
+	}
+	if err != nil {
+		return "", err
+	}
+	if ownerID == session.UserID {
+		return "", errReportOwnContent
+	}
+	if r := []rune(excerpt); len(r) > maxReportExcerpt {
+		excerpt = string(r[:maxReportExcerpt]) + "…"
+	}
+	return excerpt, nil
+}
+
+// ReportsHandler files a report on POST with a {"target_type", "target_id",
+// "category", "details"} body, and lists the caller's own reports on GET.
+// Filing the same target again while the first report is pending returns
+// that report instead of a new one.
+func ReportsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := GetSession(db, r)
+		if session == nil || session.ExpiresAt.Before(time.Now()) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			rows, err := db.Query(`
+				SELECT `+reportColumns+` FROM reports r `+reportJoins+`
+				WHERE r.reporter_id = ?
+				ORDER BY r.id DESC LIMIT 100`, session.UserID)
+			if err != nil {
+				log.Printf("Database error loading reports: %v", err)
+				http.Error(w, "Failed to fetch reports", http.StatusInternalServerError)
+				return
+			}
+			defer rows.Close()
+
+			reports := []models.Report{}
+			for rows.Next() {
+				rep, err := scanReport(rows)
+				if err != nil {
+					http.Error(w, "Error scanning reports", http.StatusInternalServerError)
+					return
+				}
+				reports = append(reports, rep)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(reports)
+
+		case http.MethodPost:
+			if !requireVerified(w, session, ActionReport) {
+				return
+			}
+
+			var requestData struct {
+				TargetType string `json:"target_type"`
+				TargetID   string `json:"target_id"`
+				Category   string `json:"category"`
+				Details    string `json:"details"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+				http.Error(w, "Invalid report data", http.StatusBadRequest)
+				return
+			}
+			requestData.Details = strings.TrimSpace(requestData.Details)
+			if !reportCategories[requestData.Category] {
+				http.Error(w, "Category must be spam, harassment, hate, sexual, violence, misinformation or other", http.StatusBadRequest)
+				return
+			}
+			if requestData.Category == "other" && requestData.Details == "" {
+				http.Error(w, "Please describe the problem", http.StatusBadRequest)
+				return
+			}
+			if len([]rune(requestData.Details)) > maxReportDetails {
+				http.Error(w, "Details must be at most 1000 characters", http.StatusBadRequest)
+				return
+			}
+
+			excerpt, err := reportTarget(db, session, requestData.TargetType, requestData.TargetID)
+			if err == sql.ErrNoRows {
+				http.Error(w, "Report target not found", http.StatusNotFound)
+				return
+			} else if err == errUnknownReportTarget || err == errReportOwnContent {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			} else if err != nil {
+				log.Printf("Database error looking up report target: %v", err)
+				http.Error(w, "Failed to file report", http.StatusInternalServerError)
+				return
+			}
+
+			// The partial unique index turns a second pending report into a no-op
+			res, err := db.Exec(`
+				INSERT INTO reports (reporter_id, target_type, target_id, excerpt, category, details, created_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT DO NOTHING`,
+				session.UserID, requestData.TargetType, requestData.TargetID, excerpt, requestData.Category,
+				requestData.Details, time.Now(),
+			)
+			if err != nil {
+				log.Printf("Database error filing report: %v", err)
+				http.Error(w, "Failed to file report", http.StatusInternalServerError)
+				return
+			}
+
+			status := http.StatusCreated
+			var id int64
+			if n, _ := res.RowsAffected(); n == 0 {
+				status = http.StatusOK
+				err = db.QueryRow(`
+					SELECT id FROM reports
+					WHERE reporter_id = ? AND target_type = ? AND target_id = ? AND status IN (?, ?)`,
+					session.UserID, requestData.TargetType, requestData.TargetID, reportOpen, reportClaimed,
+				).Scan(&id)
+			} else {
+				id, err = res.LastInsertId()
+			}
+			if err != nil {
+				http.Error(w, "Failed to file report", http.StatusInternalServerError)
+				return
+			}
+			if status == http.StatusCreated {
+				log.Printf("%s reported %s %s for %s", session.Nickname, requestData.TargetType, requestData.TargetID, requestData.Category)
+			}
+
+			rep, err := loadReport(db, id)
+			if err != nil {
+				http.Error(w, "Failed to fetch report", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(rep)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// closeReport resolves or dismisses a pending report that is unclaimed or
+// claimed by the moderator, and links the moderation log entry for it. A
+// post_action ("hide", "lock", ...) on a post report is applied to the post
+// and its log entry is the one linked; otherwise the closing itself is logged.
+func closeReport(db *sql.DB, moderatorID string, id int64, status, postAction, note string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var targetType, targetID, category string
+	err = tx.QueryRow(`
+		SELECT target_type, target_id, category FROM reports
+		WHERE id = ? AND status IN (?, ?) AND (claimed_by IS NULL OR claimed_by = ?)`,
+		id, reportOpen, reportClaimed, moderatorID,
+	).Scan(&targetType, &targetID, &category)
+	if err != nil {
+		return err
+	}
+
+	var logID int64
+	if postAction != "" {
+		if targetType != "post" {
+			return errReportPostAction
+		}
+		reason := note
+		if reason == "" {
+			reason = "Reported for " + category
+		}
+		logID, err = applyPostModeration(tx, moderatorID, targetID, postAction, reason)
+		if err == sql.ErrNoRows {
+			err = errReportedPostGone
+		}
+	} else {
+		action := "resolve_report"
+		if status == reportDismissed {
+			action = "dismiss_report"
+		}
+		logID, err = logModeration(tx, moderatorID, action, "report", strconv.FormatInt(id, 10), note)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE reports SET status = ?, resolved_by = ?, resolved_at = ?, resolution_note = ?, moderation_log_id = ?
+		WHERE id = ?`,
+		status, moderatorID, time.Now(), note, logID, id,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReportQueueHandler is the moderators' report queue.
+//
+// GET lists reports, oldest first. Query parameters: status (open, claimed,
+// resolved or dismissed; open and claimed by default), target_type, mine=1 for
+// reports claimed by the caller, limit, and after (a report ID, to page on).
+//
+// POST takes {"id", "action", "note", "post_action"} where action is claim,
+// resolve or dismiss. Reports claimed by another moderator can't be claimed
+// or closed. Resolving may apply a post_action to a reported post.
+func ReportQueueHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := requirePermission(db, w, r, PermModerate)
+		if session == nil {
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			query := r.URL.Query()
+			sqlQuery := `SELECT ` + reportColumns + ` FROM reports r ` + reportJoins + ` WHERE 1 = 1`
+			var args []interface{}
+			switch status := query.Get("status"); status {
+			case "":
+				sqlQuery += " AND r.status IN (?, ?)"
+				args = append(args, reportOpen, reportClaimed)
+			case reportOpen, reportClaimed, reportResolved, reportDismissed:
+				sqlQuery += " AND r.status = ?"
+				args = append(args, status)
+			default:
+				http.Error(w, "status must be open, claimed, resolved or dismissed", http.StatusBadRequest)
+				return
+			}
+			if targetType := query.Get("target_type"); targetType != "" {
+				sqlQuery += " AND r.target_type = ?"
+				args = append(args, targetType)
+			}
+			if query.Get("mine") == "1" {
+				sqlQuery += " AND r.claimed_by = ?"
+				args = append(args, session.UserID)
+			}
+			if after := query.Get("after"); after != "" {
+				id, err := strconv.ParseInt(after, 10, 64)
+				if err != nil {
+					http.Error(w, "after must be a report ID", http.StatusBadRequest)
+					return
+				}
+				sqlQuery += " AND r.id > ?"
+				args = append(args, id)
+			}
+
+			limit := 50
+			if l := query.Get("limit"); l != "" {
+				if n, err := strconv.Atoi(l); err == nil && n > 0 && n <= 200 {
+					limit = n
+				}
+			}
+			sqlQuery += " ORDER BY r.id LIMIT ?"
+			args = append(args, limit)
+
+			rows, err := db.Query(sqlQuery, args...)
+			if err != nil {
+				log.Printf("Database error loading report queue: %v", err)
+				http.Error(w, "Failed to fetch reports", http.StatusInternalServerError)
+				return
+			}
+			defer rows.Close()
+
+			reports := []models.Report{}
+			for rows.Next() {
+				rep, err := scanReport(rows)
+				if err != nil {
+					http.Error(w, "Error scanning reports", http.StatusInternalServerError)
+					return
+				}
+				reports = append(reports, rep)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(reports)
+
+		case http.MethodPost:
+			var requestData struct {
+				ID         int64  `json:"id"`
+				Action     string `json:"action"`
+				Note       string `json:"note"`
+				PostAction string `json:"post_action"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+				http.Error(w, "Invalid report data", http.StatusBadRequest)
+				return
+			}
+			requestData.Note = strings.TrimSpace(requestData.Note)
+			if requestData.PostAction != "" {
+				if _, ok := postModerationActions[requestData.PostAction]; !ok || requestData.Action != "resolve" {
+					http.Error(w, errReportPostAction.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+
+			var err error
+			switch requestData.Action {
+			case "claim":
+				var res sql.Result
+				res, err = db.Exec(`
+					UPDATE reports SET status = ?, claimed_by = ?, claimed_at = ?
+					WHERE id = ? AND (status = ? OR (status = ? AND claimed_by = ?))`,
+					reportClaimed, session.UserID, time.Now(), requestData.ID, reportOpen, reportClaimed, session.UserID,
+				)
+				if err == nil {
+					if n, _ := res.RowsAffected(); n == 0 {
+						err = sql.ErrNoRows
+					}
+				}
+			case "resolve":
+				err = closeReport(db, session.UserID, requestData.ID, reportResolved, requestData.PostAction, requestData.Note)
+			case "dismiss":
+				err = closeReport(db, session.UserID, requestData.ID, reportDismissed, "", requestData.Note)
+			default:
+				http.Error(w, "Action must be claim, resolve or dismiss", http.StatusBadRequest)
+				return
+			}
+
+			if err == sql.ErrNoRows {
+				// Tell a missing report apart from one someone else has or that is closed
+				if _, err := loadReport(db, requestData.ID); err == sql.ErrNoRows {
+					http.Error(w, "Report not found", http.StatusNotFound)
+				} else {
+					http.Error(w, "Report is claimed by another moderator or already closed", http.StatusConflict)
+				}
+				return
+			} else if err == errReportPostAction {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			} else if err == errReportedPostGone {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			} else if err != nil {
+				log.Printf("Error updating report: %v", err)
+				http.Error(w, "Failed to update report", http.StatusInternalServerError)
+				return
+			}
+			log.Printf("%s applied %s to report %d", session.Nickname, requestData.Action, requestData.ID)
+
+			rep, err := loadReport(db, requestData.ID)
+			if err != nil {
+				http.Error(w, "Failed to fetch report", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(rep)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"real-time-forum/models"
+	"testing"
+)
+
+func TestReportsAreDeduplicatedAndScoped(t *testing.T) {
+	dbConn := newTestDB(t)
+	alice := newTestUser(t, dbConn, "alice-id", "alice")
+	bob := newTestUser(t, dbConn, "bob-id", "bob")
+	carol := newTestUser(t, dbConn, "carol-id", "carol")
+	_, err := dbConn.Exec(`
+		INSERT INTO posts (id, user_id, title, content) VALUES ('p1', 'alice-id', 'Buy now', 'c');
+		INSERT INTO chats (id, user1_id, user2_id) VALUES (1, 'alice-id', 'bob-id');
+		INSERT INTO messages (id, chat_id, sender_id, content) VALUES (7, 1, 'alice-id', 'rude');`)
+	if err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	handler := ReportsHandler(dbConn)
+
+	rec := doRequest(t, handler, bob, "POST", "/api/reports", `{"target_type":"post","target_id":"p1","category":"spam"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("file report: status %d: %s", rec.Code, rec.Body)
+	}
+	var first models.Report
+	json.NewDecoder(rec.Body).Decode(&first)
+	if first.Status != reportOpen || first.Excerpt != "Buy now" {
+		t.Errorf("new report = %+v, want open with the post title as excerpt", first)
+	}
+
+	rec = doRequest(t, handler, bob, "POST", "/api/reports", `{"target_type":"post","target_id":"p1","category":"hate"}`)
+	var again models.Report
+	json.NewDecoder(rec.Body).Decode(&again)
+	if rec.Code != http.StatusOK || again.ID != first.ID {
+		t.Errorf("duplicate report: status %d, id %d, want 200 and id %d", rec.Code, again.ID, first.ID)
+	}
+	if rec := doRequest(t, handler, carol, "POST", "/api/reports", `{"target_type":"post","target_id":"p1","category":"spam"}`); rec.Code != http.StatusCreated {
+		t.Errorf("second reporter: status %d, want 201", rec.Code)
+	}
+
+	for name, tc := range map[string]struct {
+		cookie *http.Cookie
+		body   string
+		want   int
+	}{
+		"unknown category":     {bob, `{"target_type":"post","target_id":"p1","category":"rude"}`, http.StatusBadRequest},
+		"other without detail": {bob, `{"target_type":"user","target_id":"alice-id","category":"other"}`, http.StatusBadRequest},
+		"own post":             {alice, `{"target_type":"post","target_id":"p1","category":"spam"}`, http.StatusBadRequest},
+		"missing post":         {bob, `{"target_type":"post","target_id":"nope","category":"spam"}`, http.StatusNotFound},
+		"someone else's chat":  {carol, `{"target_type":"message","target_id":"7","category":"harassment"}`, http.StatusNotFound},
+		"message in own chat":  {bob, `{"target_type":"message","target_id":"7","category":"harassment"}`, http.StatusCreated},
+		"user":                 {bob, `{"target_type":"user","target_id":"alice-id","category":"other","details":"impersonation"}`, http.StatusCreated},
+	} {
+		if rec := doRequest(t, handler, tc.cookie, "POST", "/api/reports", tc.body); rec.Code != tc.want {
+			t.Errorf("%s: status %d, want %d: %s", name, rec.Code, tc.want, rec.Body)
+		}
+	}
+
+	var mine []models.Report
+	json.NewDecoder(doRequest(t, handler, bob, "GET", "/api/reports", "").Body).Decode(&mine)
+	if len(mine) != 3 {
+		t.Errorf("bob's reports = %d, want 3", len(mine))
+	}
+}
+
+func TestReportQueueClaimResolveAndDismiss(t *testing.T) {
+	dbConn := newTestDB(t)
+	newTestUser(t, dbConn, "alice-id", "alice")
+	bob := newTestUser(t, dbConn, "bob-id", "bob")
+	mod := newTestUser(t, dbConn, "mod-id", "mod")
+	other := newTestUser(t, dbConn, "other-id", "other")
+	SetRole(dbConn, "mod", RoleModerator)
+	SetRole(dbConn, "other", RoleModerator)
+	_, err := dbConn.Exec(`INSERT INTO posts (id, user_id, title, content) VALUES ('p1', 'alice-id', 't', 'c')`)
+	if err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	file := func(body string) int64 {
+		t.Helper()
+		rec := doRequest(t, ReportsHandler(dbConn), bob, "POST", "/api/reports", body)
+		var rep models.Report
+		json.NewDecoder(rec.Body).Decode(&rep)
+		return rep.ID
+	}
+	postReport := file(`{"target_type":"post","target_id":"p1","category":"spam"}`)
+	userReport := file(`{"target_type":"user","target_id":"alice-id","category":"harassment"}`)
+
+	queue := RequireRole(dbConn, RoleModerator, ReportQueueHandler(dbConn))
+	if rec := doRequest(t, queue, bob, "GET", "/api/moderation/reports", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("user reading the queue: status %d, want 403", rec.Code)
+	}
+	var pending []models.Report
+	json.NewDecoder(doRequest(t, queue, mod, "GET", "/api/moderation/reports", "").Body).Decode(&pending)
+	if len(pending) != 2 {
+		t.Fatalf("queue = %d reports, want 2", len(pending))
+	}
+
+	act := func(cookie *http.Cookie, format string, args ...interface{}) (int, models.Report) {
+		t.Helper()
+		rec := doRequest(t, queue, cookie, "POST", "/api/moderation/reports", fmt.Sprintf(format, args...))
+		var rep models.Report
+		json.NewDecoder(rec.Body).Decode(&rep)
+		return rec.Code, rep
+	}
+	if code, rep := act(mod, `{"id":%d,"action":"claim"}`, postReport); code != http.StatusOK || rep.ClaimedByNickname != "mod" {
+		t.Fatalf("claim: status %d, report %+v", code, rep)
+	}
+	if code, _ := act(other, `{"id":%d,"action":"claim"}`, postReport); code != http.StatusConflict {
+		t.Errorf("claiming a claimed report: status %d, want 409", code)
+	}
+	if code, _ := act(other, `{"id":%d,"action":"dismiss"}`, postReport); code != http.StatusConflict {
+		t.Errorf("dismissing another moderator's report: status %d, want 409", code)
+	}
+	if code, _ := act(mod, `{"id":%d,"action":"resolve","post_action":"hide"}`, userReport); code != http.StatusBadRequest {
+		t.Errorf("post_action on a user report: status %d, want 400", code)
+	}
+
+	// Resolving with a post action hides the post and links its log entry
+	code, resolved := act(mod, `{"id":%d,"action":"resolve","post_action":"hide","note":"ad spam"}`, postReport)
+	if code != http.StatusOK || resolved.Status != reportResolved || resolved.ModerationLogID == nil {
+		t.Fatalf("resolve: status %d, report %+v", code, resolved)
+	}
+	var action, targetID, reason string
+	dbConn.QueryRow(`SELECT action, target_id, reason FROM moderation_log WHERE id = ?`, *resolved.ModerationLogID).Scan(&action, &targetID, &reason)
+	if action != "hide" || targetID != "p1" || reason != "ad spam" {
+		t.Errorf("linked log entry = %s %s %q, want hide p1 \"ad spam\"", action, targetID, reason)
+	}
+	var hidden bool
+	dbConn.QueryRow(`SELECT hidden_at IS NOT NULL FROM posts WHERE id = 'p1'`).Scan(&hidden)
+	if !hidden {
+		t.Error("resolving with post_action=hide did not hide the post")
+	}
+
+	code, dismissed := act(other, `{"id":%d,"action":"dismiss","note":"banter"}`, userReport)
+	if code != http.StatusOK || dismissed.Status != reportDismissed || dismissed.ModerationLogID == nil {
+		t.Fatalf("dismiss: status %d, report %+v", code, dismissed)
+	}
+	dbConn.QueryRow(`SELECT action, target_id FROM moderation_log WHERE id = ?`, *dismissed.ModerationLogID).Scan(&action, &targetID)
+	if action != "dismiss_report" || targetID != fmt.Sprint(userReport) {
+		t.Errorf("dismiss log entry = %s %s, want dismiss_report %d", action, targetID, userReport)
+	}
+	if code, _ := act(mod, `{"id":%d,"action":"resolve"}`, userReport); code != http.StatusConflict {
+		t.Errorf("resolving a closed report: status %d, want 409", code)
+	}
+
+	json.NewDecoder(doRequest(t, queue, mod, "GET", "/api/moderation/reports", "").Body).Decode(&pending)
+	if len(pending) != 0 {
+		t.Errorf("queue after closing = %d reports, want 0", len(pending))
+	}
+
+	// Once closed, the same target can be reported again
+	if id := file(`{"target_type":"user","target_id":"alice-id","category":"harassment"}`); id == userReport || id == 0 {
+		t.Errorf("new report after dismissal got id %d", id)
+	}
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"real-time-forum/models"
+	"time"
+)
+
+// Roles, from least to most privileged. Every account starts as RoleUser.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRank = map[string]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+// Permissions handlers check through can. Ordinary participation (posting,
+// commenting, voting, chatting) is open to every user and not listed here.
+const (
+	PermModerate          = "moderate"
+	PermManageCategories  = "manage_categories"
+	PermManageRoles       = "manage_roles"
+	PermViewMaintenance   = "view_maintenance"
+	PermViewModerationLog = "view_moderation_log"
+)
+
+// rolePermissions is the permission matrix
+var rolePermissions = map[string]map[string]bool{
+	RoleUser: {},
+	RoleModerator: {
+		PermModerate: true,
+	},
+	RoleAdmin: {
+		PermModerate:          true,
+		PermManageCategories:  true,
+		PermManageRoles:       true,
+		PermViewMaintenance:   true,
+		PermViewModerationLog: true,
+	},
+}
+
+var errUnknownRole = errors.New("role must be user, moderator or admin")
+
+// can reports whether the session's role grants perm
+func can(session *models.Session, perm string) bool {
+	return rolePermissions[session.Role][perm]
+}
+
+// hasRole reports whether the session's role is role or a more privileged one
+func hasRole(session *models.Session, role string) bool {
+	rank, ok := roleRank[session.Role]
+	return ok && rank >= roleRank[role]
+}
+
+// requirePermission writes the error response and returns nil unless the
+// caller is logged in with a role that grants perm
+func requirePermission(db *sql.DB, w http.ResponseWriter, r *http.Request, perm string) *models.Session {
+	session := GetSession(db, r)
+	if session == nil || session.ExpiresAt.Before(time.Now()) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+	if !can(session, perm) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil
+	}
+	return session
+}
+
+// RequireRole only lets callers with role, or a more privileged one, reach next
+func RequireRole(db *sql.DB, role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := GetSession(db, r)
+		if session == nil || session.ExpiresAt.Before(time.Now()) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !hasRole(session, role) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// SetRole changes the role of the user with the given nickname. It returns
+// sql.ErrNoRows if there is no such user.
+func SetRole(db *sql.DB, nickname, role string) error {
+	if _, ok := roleRank[role]; !ok {
+		return errUnknownRole
+	}
+	userID, err := userIDByNickname(db, nickname)
+	if err != nil {
+		return err
+	}
+	return setUserRole(db, userID, role)
+}
+
+// userIDByNickname resolves a nickname to a user ID. Nicknames are only unique
+// case-sensitively, so "bob" and "Bob" can be different users and the match
+// must be exact.
+func userIDByNickname(db *sql.DB, nickname string) (string, error) {
+	var userID string
+	err := db.QueryRow(`SELECT id FROM users WHERE nickname = ?`, nickname).Scan(&userID)
+	return userID, err
+}
+
+// setUserRole changes the role of exactly one user
+func setUserRole(db *sql.DB, userID, role string) error {
+	if _, ok := roleRank[role]; !ok {
+		return errUnknownRole
+	}
+	res, err := db.Exec(`UPDATE users SET role = ? WHERE id = ?`, role, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CountAdmins returns how many users have the admin role
+func CountAdmins(db *sql.DB) (int, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM users WHERE role = ?`, RoleAdmin).Scan(&n)
+	return n, err
+}
+
+// AdminRolesHandler lists staff (moderators and admins) on GET and changes a
+// user's role on PUT/PATCH with a {"nickname", "role"} body. Admins can't
+// demote themselves, so there is always at least one admin left.
+func AdminRolesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := requirePermission(db, w, r, PermManageRoles)
+		if session == nil {
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			staff, err := listStaff(db)
+			if err != nil {
+				log.Printf("Database error loading staff: %v", err)
+				http.Error(w, "Failed to fetch roles", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(staff)
+
+		case http.MethodPut, http.MethodPatch:
+			var requestData struct {
+				Nickname string `json:"nickname"`
+				Role     string `json:"role"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+				http.Error(w, "Invalid role data", http.StatusBadRequest)
+				return
+			}
+			if _, ok := roleRank[requestData.Role]; !ok {
+				http.Error(w, errUnknownRole.Error(), http.StatusBadRequest)
+				return
+			}
+
+			userID, err := userIDByNickname(db, requestData.Nickname)
+			if err == nil && userID == session.UserID && requestData.Role != RoleAdmin {
+				http.Error(w, "You can't remove your own admin role", http.StatusBadRequest)
+				return
+			}
+			if err == nil {
+				err = setUserRole(db, userID, requestData.Role)
+			}
+			if err == errUnknownRole {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			} else if err == sql.ErrNoRows {
+				http.Error(w, "User not found", http.StatusNotFound)
+				return
+			} else if err != nil {
+				log.Printf("Database error setting role: %v", err)
+				http.Error(w, "Failed to update role", http.StatusInternalServerError)
+				return
+			}
+			log.Printf("%s set the role of %s to %s", session.Nickname, requestData.Nickname, requestData.Role)
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{
+				"nickname": requestData.Nickname,
+				"role":     requestData.Role,
+			})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// listStaff returns every moderator and admin, admins first
+func listStaff(db *sql.DB) ([]map[string]string, error) {
+	rows, err := db.Query(`
+		SELECT id, nickname, role FROM users
+		WHERE role != ?
+		ORDER BY role = ? DESC, nickname COLLATE NOCASE`,
+		RoleUser, RoleAdmin,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	staff := []map[string]string{}
+	for rows.Next() {
+		var id, nickname, role string
+		if err := rows.Scan(&id, &nickname, &role); err != nil {
+			return nil, err
+		}
+		staff = append(staff, map[string]string{"id": id, "nickname": nickname, "role": role})
+	}
+	return staff, rows.Err()
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRolesGateAdminEndpoints(t *testing.T) {
+	dbConn := newTestDB(t)
+	admin := newTestUser(t, dbConn, "admin-id", "admin")
+	alice := newTestUser(t, dbConn, "alice-id", "alice")
+	if err := SetRole(dbConn, "admin", RoleAdmin); err != nil {
+		t.Fatalf("promote admin: %v", err)
+	}
+
+	roles := RequireRole(dbConn, RoleAdmin, AdminRolesHandler(dbConn))
+	if rec := doRequest(t, roles, alice, "PUT", "/api/admin/roles", `{"nickname":"alice","role":"admin"}`); rec.Code != http.StatusForbidden {
+		t.Fatalf("user promoting themselves: status %d, want 403", rec.Code)
+	}
+	if rec := doRequest(t, roles, admin, "PUT", "/api/admin/roles", `{"nickname":"alice","role":"overlord"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown role: status %d, want 400", rec.Code)
+	}
+	if rec := doRequest(t, roles, admin, "PUT", "/api/admin/roles", `{"nickname":"admin","role":"user"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("admin demoting themselves: status %d, want 400", rec.Code)
+	}
+	if rec := doRequest(t, roles, admin, "PUT", "/api/admin/roles", `{"nickname":"alice","role":"moderator"}`); rec.Code != http.StatusOK {
+		t.Fatalf("promote alice: status %d: %s", rec.Code, rec.Body)
+	}
+
+	// The new role applies to alice's existing session, and moderators aren't admins
+	if rec := doRequest(t, CheckAuthHandler(dbConn), alice, "GET", "/api/check-auth", ""); !strings.Contains(rec.Body.String(), `"role":"moderator"`) {
+		t.Errorf("check-auth did not report the new role: %s", rec.Body)
+	}
+	if rec := doRequest(t, roles, alice, "GET", "/api/admin/roles", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("moderator listing roles: status %d, want 403", rec.Code)
+	}
+	moderatorsOnly := RequireRole(dbConn, RoleModerator, func(w http.ResponseWriter, r *http.Request) {})
+	for name, cookie := range map[string]*http.Cookie{"moderator": alice, "admin": admin} {
+		if rec := doRequest(t, moderatorsOnly, cookie, "GET", "/", ""); rec.Code != http.StatusOK {
+			t.Errorf("%s on a moderator route: status %d, want 200", name, rec.Code)
+		}
+	}
+}
+
+func TestSetRoleMatchesNicknameExactly(t *testing.T) {
+	dbConn := newTestDB(t)
+	newTestUser(t, dbConn, "lower-id", "bob")
+	newTestUser(t, dbConn, "upper-id", "Bob")
+
+	if err := SetRole(dbConn, "bob", RoleAdmin); err != nil {
+		t.Fatalf("promote bob: %v", err)
+	}
+	var upperRole string
+	dbConn.QueryRow(`SELECT role FROM users WHERE id = 'upper-id'`).Scan(&upperRole)
+	if upperRole != RoleUser {
+		t.Errorf("Bob's role = %s after promoting bob, want %s", upperRole, RoleUser)
+	}
+	if n, _ := CountAdmins(dbConn); n != 1 {
+		t.Errorf("%d admins, want 1", n)
+	}
+	if err := SetRole(dbConn, "BOB", RoleAdmin); err != sql.ErrNoRows {
+		t.Errorf("promote BOB: err = %v, want sql.ErrNoRows", err)
+	}
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"html"
+	"log"
+	"net/http"
+	"real-time-forum/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sentinels handed to highlight()/snippet() so matches can be marked up after the
+// surrounding user text has been HTML-escaped
+const (
+	searchMatchStart = "\x02"
+	searchMatchEnd   = "\x03"
+)
+
+// SearchHandler runs a full-text query over posts and comments.
+//
+// Query parameters: q (required), type (posts, comments or all), category,
+// author (nickname), from and to (YYYY-MM-DD, inclusive) and limit.
+func SearchHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		session := GetSession(db, r)
+		if session == nil || session.ExpiresAt.Before(time.Now()) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !searchAvailable(db) {
+			http.Error(w, "Search is not available on this server", http.StatusServiceUnavailable)
+			return
+		}
+
+		query := r.URL.Query()
+		match := buildMatchQuery(query.Get("q"))
+		if match == "" {
+			http.Error(w, "Search query is required", http.StatusBadRequest)
+			return
+		}
+
+		searchType := query.Get("type")
+		if searchType == "" {
+			searchType = "all"
+		}
+		if searchType != "all" && searchType != "posts" && searchType != "comments" {
+			http.Error(w, "type must be posts, comments or all", http.StatusBadRequest)
+			return
+		}
+
+		limit := 20
+		if l := query.Get("limit"); l != "" {
+			if n, err := strconv.Atoi(l); err == nil && n > 0 && n <= 50 {
+				limit = n
+			}
+		}
+
+		filters := searchFilters{
+			IncludeHidden: can(session, PermModerate),
+			Author:        query.Get("author"),
+		}
+		if category := query.Get("category"); category != "all" {
+			filters.Category = category
+		}
+		if from := query.Get("from"); from != "" {
+			day, err := time.ParseInLocation("2006-01-02", from, time.Local)
+			if err != nil {
+				http.Error(w, "from must be a YYYY-MM-DD date", http.StatusBadRequest)
+				return
+			}
+			filters.From = day
+		}
+		if to := query.Get("to"); to != "" {
+			day, err := time.ParseInLocation("2006-01-02", to, time.Local)
+			if err != nil {
+				http.Error(w, "to must be a YYYY-MM-DD date", http.StatusBadRequest)
+				return
+			}
+			filters.To = day.AddDate(0, 0, 1)
+		}
+
+		response := map[string]interface{}{
+			"query":    query.Get("q"),
+			"posts":    []models.SearchResult{},
+			"comments": []models.SearchResult{},
+		}
+
+		if searchType != "comments" {
+			results, err := searchPosts(db, match, filters, limit)
+			if err != nil {
+				log.Printf("Database error searching posts: %v", err)
+				http.Error(w, "Search failed", http.StatusInternalServerError)
+				return
+			}
+			response["posts"] = results
+		}
+		if searchType != "posts" {
+			results, err := searchComments(db, match, filters, limit)
+			if err != nil {
+				log.Printf("Database error searching comments: %v", err)
+				http.Error(w, "Search failed", http.StatusInternalServerError)
+				return
+			}
+			response["comments"] = results
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// searchFilters narrow both result kinds. They apply to the post and its author
+// for posts, and to the comment's post and the comment's author for comments.
+type searchFilters struct {
+	IncludeHidden bool
+	Category      string
+	Author        string
+	From, To      time.Time // creation time range, To exclusive; zero means unbounded
+}
+
+// where returns the filter conditions, each starting with AND, and their
+// arguments. createdAt is the creation column of the result kind. Times are
+// compared as Julian days: rows hold both SQLite's CURRENT_TIMESTAMP text and
+// Go timestamps with a zone offset, which don't sort correctly as text.
+func (f searchFilters) where(createdAt string) (string, []interface{}) {
+	var conditions string
+	var args []interface{}
+	if !f.IncludeHidden {
+		conditions += " AND p.hidden_at IS NULL"
+	}
+	if f.Category != "" {
+		conditions += " AND p.category_id = ?"
+		args = append(args, f.Category)
+	}
+	if f.Author != "" {
+		conditions += " AND u.nickname = ? COLLATE NOCASE"
+		args = append(args, f.Author)
+	}
+	if !f.From.IsZero() {
+		conditions += " AND julianday(" + createdAt + ") >= julianday(?)"
+		args = append(args, f.From.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if !f.To.IsZero() {
+		conditions += " AND julianday(" + createdAt + ") < julianday(?)"
+		args = append(args, f.To.UTC().Format("2006-01-02 15:04:05"))
+	}
+	return conditions, args
+}
+
+// searchPosts ranks posts by bm25, weighting title matches above content matches
+func searchPosts(db *sql.DB, match string, filters searchFilters, limit int) ([]models.SearchResult, error) {
+	query := `
+		SELECT p.id, p.category_id, p.user_id, u.nickname, p.created_at,
+			highlight(posts_fts, 1, ?, ?),
+			snippet(posts_fts, 2, ?, ?, '…', 16),
+			bm25(posts_fts, 0.0, 10.0, 1.0) AS score
+		FROM posts_fts
+		JOIN posts p ON p.id = posts_fts.post_id
+		JOIN users u ON u.id = p.user_id
+		WHERE posts_fts MATCH ? AND p.deleted_at IS NULL`
+	args := []interface{}{searchMatchStart, searchMatchEnd, searchMatchStart, searchMatchEnd, match}
+	conditions, filterArgs := filters.where("p.created_at")
+	query += conditions
+	args = append(args, filterArgs...)
+	query += " ORDER BY score LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []models.SearchResult{}
+	for rows.Next() {
+		res := models.SearchResult{Type: "post"}
+		if err := rows.Scan(&res.PostID, &res.CategoryID, &res.UserID, &res.Nickname, &res.CreatedAt,
+			&res.Title, &res.Snippet, &res.Rank); err != nil {
+			return nil, err
+		}
+		res.Title = markMatches(res.Title)
+		res.Snippet = markMatches(res.Snippet)
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+// searchComments ranks live comments on live posts by bm25
+func searchComments(db *sql.DB, match string, filters searchFilters, limit int) ([]models.SearchResult, error) {
+	query := `
+		SELECT c.id, p.id, p.title, p.category_id, c.user_id, u.nickname, c.created_at,
+			snippet(comments_fts, 1, ?, ?, '…', 16),
+			bm25(comments_fts) AS score
+		FROM comments_fts
+		JOIN comments c ON c.id = comments_fts.comment_id
+		JOIN posts p ON p.id = c.post_id
+		JOIN users u ON u.id = c.user_id
+		WHERE comments_fts MATCH ? AND c.deleted_at IS NULL AND p.deleted_at IS NULL`
+	args := []interface{}{searchMatchStart, searchMatchEnd, match}
+	conditions, filterArgs := filters.where("c.created_at")
+	query += conditions
+	args = append(args, filterArgs...)
+	query += " ORDER BY score LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []models.SearchResult{}
+	for rows.Next() {
+		res := models.SearchResult{Type: "comment"}
+		if err := rows.Scan(&res.CommentID, &res.PostID, &res.Title, &res.CategoryID, &res.UserID, &res.Nickname,
+			&res.CreatedAt, &res.Snippet, &res.Rank); err != nil {
+			return nil, err
+		}
+		res.Title = html.EscapeString(res.Title)
+		res.Snippet = markMatches(res.Snippet)
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+// buildMatchQuery turns free text into an FTS5 query that ANDs every word as a
+// quoted phrase, so user input can't inject FTS5 operators or syntax errors.
+// The last word is matched as a prefix to support search-as-you-type.
+func buildMatchQuery(text string) string {
+	words := strings.Fields(text)
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		terms = append(terms, `"`+strings.ReplaceAll(word, `"`, `""`)+`"`)
+	}
+	if len(terms) == 0 {
+		return ""
+	}
+	terms[len(terms)-1] += "*"
+	return strings.Join(terms, " ")
+}
+
+// markMatches HTML-escapes text and turns the match sentinels into <mark> tags
+func markMatches(text string) string {
+	text = html.EscapeString(text)
+	text = strings.ReplaceAll(text, searchMatchStart, "<mark>")
+	return strings.ReplaceAll(text, searchMatchEnd, "</mark>")
+}
+
+// searchAvailable reports whether the FTS5 index was set up at startup. Its
+// triggers are only present while the running SQLite build has FTS5.
+func searchAvailable(db *sql.DB) bool {
+	var exists bool
+	err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'trigger' AND name = 'comments_fts_insert')`).Scan(&exists)
+	return err == nil && exists
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"real-time-forum/db"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearchPostsAndComments(t *testing.T) {
+	dbConn := newTestDB(t)
+	if !searchAvailable(dbConn) {
+		t.Skip("SQLite built without FTS5; run with -tags sqlite_fts5")
+	}
+	alice := newTestUser(t, dbConn, "alice-id", "alice")
+	newTestUser(t, dbConn, "bob-id", "bob")
+
+	_, err := dbConn.Exec(`
+		INSERT INTO posts (id, user_id, category_id, title, content) VALUES
+			('p1', 'alice-id', 'golang', 'Goroutines explained', 'Channels and <b>select</b>'),
+			('p2', 'bob-id', 'css', 'Flexbox tips', 'Nothing about goroutines here'),
+			('p3', 'bob-id', 'golang', 'Deleted goroutines post', 'gone');
+		UPDATE posts SET deleted_at = CURRENT_TIMESTAMP WHERE id = 'p3';
+		INSERT INTO comments (id, post_id, user_id, nickname, content) VALUES
+			('c1', 'p2', 'alice-id', 'alice', 'Try goroutines instead');
+		UPDATE posts SET title = 'Goroutines, explained' WHERE id = 'p1';`)
+	if err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	search := func(query string) (posts, comments []map[string]interface{}) {
+		t.Helper()
+		rec := doRequest(t, SearchHandler(dbConn), alice, "GET", "/api/search?"+query, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("search %s: status %d: %s", query, rec.Code, rec.Body)
+		}
+		var body struct {
+			Posts    []map[string]interface{} `json:"posts"`
+			Comments []map[string]interface{} `json:"comments"`
+		}
+		json.NewDecoder(rec.Body).Decode(&body)
+		return body.Posts, body.Comments
+	}
+
+	posts, comments := search("q=goroutine")
+	if len(posts) != 2 || posts[0]["post_id"] != "p1" {
+		t.Fatalf("posts = %v, want p1 ranked above p2 and no deleted post", posts)
+	}
+	if title := posts[0]["title"].(string); !strings.Contains(title, "<mark>Goroutines</mark>") {
+		t.Errorf("title %q is not highlighted", title)
+	}
+	if len(comments) != 1 || comments[0]["comment_id"] != "c1" {
+		t.Errorf("comments = %v, want c1", comments)
+	}
+
+	// User text is escaped, only the highlight markup is HTML
+	posts, _ = search("q=select&type=posts")
+	if len(posts) != 1 || !strings.Contains(posts[0]["snippet"].(string), "&lt;b&gt;<mark>select</mark>&lt;/b&gt;") {
+		t.Errorf("posts = %v, want escaped snippet", posts)
+	}
+
+	posts, comments = search("q=goroutines&category=golang&author=ALICE")
+	if len(posts) != 1 || len(comments) != 0 {
+		t.Errorf("filtered search = %v / %v, want only p1", posts, comments)
+	}
+
+	if rec := doRequest(t, SearchHandler(dbConn), alice, "GET", "/api/search?q=%22", ""); rec.Code != http.StatusOK {
+		t.Errorf("stray quote: status %d, want 200", rec.Code)
+	}
+}
+
+// The filters don't need FTS5, so they are checked against a plain join
+func TestSearchFiltersCompareTimesAcrossFormats(t *testing.T) {
+	dbConn := newTestDB(t)
+	newTestUser(t, dbConn, "alice-id", "alice")
+	newTestUser(t, dbConn, "bob-id", "bob")
+
+	// p1 and p2 are the same instant, written by CURRENT_TIMESTAMP and by Go with an offset
+	_, err := dbConn.Exec(`
+		INSERT INTO posts (id, user_id, category_id, title, content, created_at) VALUES
+			('p1', 'alice-id', 'golang', 't', 'c', '2026-03-01 23:30:00'),
+			('p2', 'alice-id', 'golang', 't', 'c', '2026-03-02 01:30:00+02:00'),
+			('p3', 'alice-id', 'golang', 't', 'c', '2026-03-02 00:30:00'),
+			('p4', 'bob-id', 'css', 't', 'c', '2026-03-02 00:30:00'),
+			('p5', 'alice-id', 'golang', 't', 'c', '2026-03-03 00:00:00');
+		UPDATE posts SET hidden_at = CURRENT_TIMESTAMP WHERE id = 'p3';`)
+	if err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	matching := func(f searchFilters) string {
+		t.Helper()
+		conditions, args := f.where("p.created_at")
+		rows, err := dbConn.Query(`
+			SELECT p.id FROM posts p JOIN users u ON u.id = p.user_id
+			WHERE p.deleted_at IS NULL`+conditions+` ORDER BY p.id`, args...)
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		defer rows.Close()
+		var ids []string
+		for rows.Next() {
+			var id string
+			rows.Scan(&id)
+			ids = append(ids, id)
+		}
+		return strings.Join(ids, " ")
+	}
+
+	day := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	for name, tc := range map[string]struct {
+		filters searchFilters
+		want    string
+	}{
+		"from":             {searchFilters{IncludeHidden: true, From: day}, "p3 p4 p5"},
+		"to":               {searchFilters{IncludeHidden: true, To: day}, "p1 p2"},
+		"one day":          {searchFilters{IncludeHidden: true, From: day, To: day.AddDate(0, 0, 1)}, "p3 p4"},
+		"offset zone":      {searchFilters{IncludeHidden: true, From: day.In(time.FixedZone("", 2*3600))}, "p3 p4 p5"},
+		"hidden excluded":  {searchFilters{From: day}, "p4 p5"},
+		"category, author": {searchFilters{IncludeHidden: true, Category: "golang", Author: "ALICE", From: day}, "p3 p5"},
+	} {
+		if got := matching(tc.filters); got != tc.want {
+			t.Errorf("%s: got %q, want %q", name, got, tc.want)
+		}
+	}
+}
+
+func TestDatabaseIndexedWithFTS5StaysWritableWithoutIt(t *testing.T) {
+	dbConn := newTestDB(t)
+	if searchAvailable(dbConn) {
+		t.Skip("SQLite built with FTS5; this covers builds without -tags sqlite_fts5")
+	}
+	alice := newTestUser(t, dbConn, "alice-id", "alice")
+
+	// Recreate what an FTS5 build leaves behind: the virtual tables, which this
+	// build cannot create, and the triggers that write to them
+	var version int
+	dbConn.QueryRow(`PRAGMA schema_version`).Scan(&version)
+	_, err := dbConn.Exec(fmt.Sprintf(`
+		PRAGMA writable_schema = ON;
+		INSERT INTO sqlite_master (type, name, tbl_name, rootpage, sql) VALUES
+			('table', 'posts_fts', 'posts_fts', 0, 'CREATE VIRTUAL TABLE posts_fts USING fts5(post_id UNINDEXED, title, content)'),
+			('table', 'comments_fts', 'comments_fts', 0, 'CREATE VIRTUAL TABLE comments_fts USING fts5(comment_id UNINDEXED, content)');
+		PRAGMA schema_version = %d;
+		PRAGMA writable_schema = OFF;
+		CREATE TRIGGER posts_fts_insert AFTER INSERT ON posts BEGIN
+			INSERT INTO posts_fts (post_id, title, content) VALUES (new.id, new.title, new.content);
+		END;
+		CREATE TRIGGER comments_fts_insert AFTER INSERT ON comments BEGIN
+			INSERT INTO comments_fts (comment_id, content) VALUES (new.id, new.content);
+		END;`, version+1))
+	if err != nil {
+		t.Fatalf("simulate FTS5 schema: %v", err)
+	}
+	if _, err := dbConn.Exec(`INSERT INTO posts (id, user_id, title, content) VALUES ('p0', 'alice-id', 't', 'c')`); err == nil {
+		t.Fatal("insert succeeded before startup dropped the search triggers")
+	}
+
+	db.InitializeSchema(dbConn)
+
+	rec := doRequest(t, PostsHandler(dbConn), alice, "POST", "/api/posts", `{"title":"Still works","content":"c"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create post: status %d: %s", rec.Code, rec.Body)
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	json.NewDecoder(rec.Body).Decode(&created)
+	postComment(t, CommentsHandler(dbConn), alice, created.ID, "", "and comments too")
+
+	if rec := doRequest(t, SearchHandler(dbConn), alice, "GET", "/api/search?q=works", ""); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("search without FTS5: status %d, want 503", rec.Code)
+	}
+}
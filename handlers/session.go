@@ -9,57 +9,120 @@ import (
 	"github.com/gofrs/uuid"
 )
 
-// CreateSession inserts a new session and sets a cookie
-func CreateSession(db *sql.DB, w http.ResponseWriter, userID, nickname string) (string, error) {
+// SessionPolicy decides how long sessions last and how their cookie is set.
+// A session ends after IdleTimeout without requests or MaxLifetime after login,
+// whichever comes first. "Remember me" logins use the RememberMe limits instead
+// and get a persistent cookie; other cookies last until the browser closes.
+type SessionPolicy struct {
+	IdleTimeout           time.Duration
+	MaxLifetime           time.Duration
+	RememberMeIdleTimeout time.Duration
+	RememberMeLifetime    time.Duration
+	SecureCookie          bool
+	SameSite              http.SameSite
+}
+
+// Sessions is the policy applied to every session; main overrides it from the environment
+var Sessions = SessionPolicy{
+	IdleTimeout:           30 * time.Minute,
+	MaxLifetime:           12 * time.Hour,
+	RememberMeIdleTimeout: 7 * 24 * time.Hour,
+	RememberMeLifetime:    30 * 24 * time.Hour,
+	SameSite:              http.SameSiteLaxMode,
+}
+
+// limits returns the idle timeout and absolute lifetime for a session
+func (p SessionPolicy) limits(remember bool) (idle, lifetime time.Duration) {
+	if remember {
+		return p.RememberMeIdleTimeout, p.RememberMeLifetime
+	}
+	return p.IdleTimeout, p.MaxLifetime
+}
+
+// cookie builds the session cookie; maxAge follows http.Cookie semantics
+func (p SessionPolicy) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     "session",
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   p.SecureCookie,
+		SameSite: p.SameSite,
+		MaxAge:   maxAge,
+	}
+}
+
+// nextExpiry is when a session used at now times out, capped at its absolute expiry
+func nextExpiry(now time.Time, idle time.Duration, absolute time.Time) time.Time {
+	if expires := now.Add(idle); expires.Before(absolute) {
+		return expires
+	}
+	return absolute
+}
+
+// CreateSession inserts a new session for the client making r and sets a cookie.
+// remember selects the longer "remember me" lifetime.
+func CreateSession(db *sql.DB, w http.ResponseWriter, r *http.Request, userID, nickname string, remember bool) (string, error) {
 	sessionID, err := uuid.NewV4()
 	if err != nil {
 		return "", err
 	}
 	sid := sessionID.String()
-	expiresAt := time.Now().Add(15 * time.Minute)
-	lastActive := time.Now()
+	now := time.Now()
+	idle, lifetime := Sessions.limits(remember)
+	absoluteExpiresAt := now.Add(lifetime)
 
 	_, err = db.Exec(`
-		INSERT INTO sessions (id, user_id, nickname, expires_at, last_active)
-		VALUES (?, ?, ?, ?, ?)`,
-		sid, userID, nickname, expiresAt, lastActive,
+		INSERT INTO sessions (id, user_id, nickname, expires_at, absolute_expires_at, remember, last_active, created_at, user_agent, ip)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		sid, userID, nickname, nextExpiry(now, idle, absoluteExpiresAt), absoluteExpiresAt, remember, now, now, r.UserAgent(), clientIP(r),
 	)
 	if err != nil {
 		return "", err
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session",
-		Value:    sid,
-		Path:     "/",
-		HttpOnly: true,
-		MaxAge:   86400, // 1 day
-	})
+	maxAge := 0 // browser session
+	if remember {
+		maxAge = int(lifetime.Seconds())
+	}
+	http.SetCookie(w, Sessions.cookie(sid, maxAge))
 
 	return sid, nil
 }
 
-// GetSession retrieves the session info if valid
+// GetSession retrieves the session info if valid and extends its idle
+// expiry, never past its absolute expiry
 func GetSession(db *sql.DB, r *http.Request) *models.Session {
 	cookie, err := r.Cookie("session")
 	if err != nil {
 		return nil
 	}
+	return lookupSession(db, cookie.Value)
+}
 
-	var sess models.Session
-	err = db.QueryRow(`
-		SELECT user_id, nickname, expires_at FROM sessions WHERE id = ?`,
-		cookie.Value,
-	).Scan(&sess.UserID, &sess.Nickname, &sess.ExpiresAt)
+// lookupSession loads a valid session by ID and extends its idle expiry
+func lookupSession(db *sql.DB, sessionID string) *models.Session {
+	sess := models.Session{ID: sessionID}
+	var absoluteExpiresAt time.Time
+	var remember bool
+	err := db.QueryRow(`
+		SELECT s.user_id, s.nickname, s.expires_at, s.absolute_expires_at, s.remember, u.email_verified_at IS NOT NULL, u.role
+		FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.id = ?`,
+		sessionID,
+	).Scan(&sess.UserID, &sess.Nickname, &sess.ExpiresAt, &absoluteExpiresAt, &remember, &sess.EmailVerified, &sess.Role)
 
-	if err != nil || sess.ExpiresAt.Before(time.Now()) {
+	now := time.Now()
+	if err != nil || sess.ExpiresAt.Before(now) {
 		return nil
 	}
 
-	// Optionally refresh session expiry on activity
+	idle, _ := Sessions.limits(remember)
+	sess.ExpiresAt = nextExpiry(now, idle, absoluteExpiresAt)
 	_, _ = db.Exec(`
 		UPDATE sessions SET last_active = ?, expires_at = ? WHERE id = ?`,
-		time.Now(), time.Now().Add(15*time.Minute), cookie.Value,
+		now, sess.ExpiresAt, sessionID,
 	)
 
 	return &sess
@@ -72,11 +135,5 @@ func ClearSession(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		db.Exec(`DELETE FROM sessions WHERE id = ?`, cookie.Value)
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session",
-		Value:    "",
-		Path:     "/",
-		HttpOnly: true,
-		MaxAge:   -1,
-	})
+	http.SetCookie(w, Sessions.cookie("", -1))
 }
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessionIdleExpirySlidesUpToAbsoluteLimit(t *testing.T) {
+	dbConn := newTestDB(t)
+	cookie := newTestUser(t, dbConn, "alice-id", "alice")
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookie)
+
+	// Nearly at its absolute limit: activity can't extend it past that
+	absolute := time.Now().Add(time.Minute)
+	dbConn.Exec(`UPDATE sessions SET absolute_expires_at = ? WHERE id = ?`, absolute, cookie.Value)
+	session := GetSession(dbConn, req)
+	if session == nil {
+		t.Fatal("session rejected before its absolute expiry")
+	}
+	if !session.ExpiresAt.Equal(absolute) {
+		t.Errorf("expiry slid to %v, want capped at %v", session.ExpiresAt, absolute)
+	}
+
+	// Idle for too long
+	dbConn.Exec(`UPDATE sessions SET expires_at = ? WHERE id = ?`, time.Now().Add(-time.Second), cookie.Value)
+	if GetSession(dbConn, req) != nil {
+		t.Error("idle session still accepted")
+	}
+}
+
+func TestRememberMeGetsPersistentCookie(t *testing.T) {
+	dbConn := newTestDB(t)
+	newTestUser(t, dbConn, "alice-id", "alice")
+
+	for _, remember := range []bool{false, true} {
+		rec := httptest.NewRecorder()
+		if _, err := CreateSession(dbConn, rec, httptest.NewRequest("POST", "/login", nil), "alice-id", "alice", remember); err != nil {
+			t.Fatalf("create session: %v", err)
+		}
+		cookie := rec.Result().Cookies()[0]
+		if cookie.SameSite != http.SameSiteLaxMode || !cookie.HttpOnly {
+			t.Errorf("remember=%v: cookie %+v, want HttpOnly and SameSite=Lax", remember, cookie)
+		}
+
+		var expiresAt, absoluteExpiresAt time.Time
+		dbConn.QueryRow(`SELECT expires_at, absolute_expires_at FROM sessions WHERE id = ?`, cookie.Value).
+			Scan(&expiresAt, &absoluteExpiresAt)
+
+		idle, lifetime := Sessions.limits(remember)
+		if d := time.Until(expiresAt); d > idle || d < idle-time.Minute {
+			t.Errorf("remember=%v: idle expiry in %v, want %v", remember, d, idle)
+		}
+		if d := time.Until(absoluteExpiresAt); d > lifetime || d < lifetime-time.Minute {
+			t.Errorf("remember=%v: absolute expiry in %v, want %v", remember, d, lifetime)
+		}
+
+		wantMaxAge := 0
+		if remember {
+			wantMaxAge = int(lifetime.Seconds())
+		}
+		if cookie.MaxAge != wantMaxAge {
+			t.Errorf("remember=%v: cookie MaxAge %d, want %d", remember, cookie.MaxAge, wantMaxAge)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"real-time-forum/models"
+	"time"
+)
+
+// publicSessionID identifies a session in the API. The session ID itself is the
+// cookie value, so it is never sent back to the client.
+func publicSessionID(sessionID string) string {
+	return hashToken(sessionID)[:16]
+}
+
+// SessionsHandler lets users review and revoke their sessions.
+//
+// GET lists the caller's active sessions, most recently used first.
+// DELETE with ?id= revokes one session; with ?others=1 it revokes every session
+// except the current one. Live WebSocket connections of revoked sessions are closed.
+func SessionsHandler(db *sql.DB, connManager *ConnectionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := GetSession(db, r)
+		if session == nil || session.ExpiresAt.Before(time.Now()) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			sessions, err := listSessions(db, session)
+			if err != nil {
+				log.Printf("Database error loading sessions: %v", err)
+				http.Error(w, "Failed to fetch sessions", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(sessions)
+
+		case http.MethodDelete:
+			handleRevokeSessions(db, connManager, w, r, session)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// listSessions returns the user's unexpired sessions, flagging the caller's own
+func listSessions(db *sql.DB, current *models.Session) ([]models.SessionInfo, error) {
+	rows, err := db.Query(`
+		SELECT id, created_at, last_active, user_agent, ip
+		FROM sessions
+		WHERE user_id = ? AND expires_at > ?
+		ORDER BY last_active DESC`,
+		current.UserID, time.Now(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []models.SessionInfo{}
+	for rows.Next() {
+		var id string
+		var createdAt sql.NullTime
+		var s models.SessionInfo
+		if err := rows.Scan(&id, &createdAt, &s.LastActive, &s.UserAgent, &s.IP); err != nil {
+			return nil, err
+		}
+		// Sessions from before created_at was tracked fall back to their last activity
+		s.CreatedAt = s.LastActive
+		if createdAt.Valid {
+			s.CreatedAt = createdAt.Time
+		}
+		s.ID = publicSessionID(id)
+		s.Current = id == current.ID
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+func handleRevokeSessions(db *sql.DB, connManager *ConnectionManager, w http.ResponseWriter, r *http.Request, session *models.Session) {
+	publicID := r.URL.Query().Get("id")
+	others := r.URL.Query().Get("others") == "1"
+	if publicID == "" && !others {
+		http.Error(w, "Session id or others=1 is required", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(`SELECT id FROM sessions WHERE user_id = ?`, session.UserID)
+	if err != nil {
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+	var revoke []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+			return
+		}
+		if (others && id != session.ID) || (!others && publicSessionID(id) == publicID) {
+			revoke = append(revoke, id)
+		}
+	}
+	rows.Close()
+
+	if !others && len(revoke) == 0 {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	for _, id := range revoke {
+		if _, err := db.Exec(`DELETE FROM sessions WHERE id = ? AND user_id = ?`, id, session.UserID); err != nil {
+			log.Printf("Database error revoking session: %v", err)
+			http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+			return
+		}
+		if id == session.ID {
+			ClearSession(db, w, r)
+		}
+	}
+	closed := connManager.CloseSessions(session.UserID, revoke...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{
+		"revoked":            len(revoke),
+		"closed_connections": closed,
+	})
+}
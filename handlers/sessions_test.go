@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"real-time-forum/models"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRevokingOtherSessionsClosesTheirWebSockets(t *testing.T) {
+	dbConn := newTestDB(t)
+	laptop := newTestUser(t, dbConn, "alice-id", "alice")
+
+	login := httptest.NewRequest("POST", "/login", nil)
+	login.Header.Set("User-Agent", "phone-browser")
+	rec := httptest.NewRecorder()
+	if _, err := CreateSession(dbConn, rec, login, "alice-id", "alice", false); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	phone := rec.Result().Cookies()[0]
+
+	connManager := NewConnectionManager()
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	server := httptest.NewServer(HandleWebSocket(dbConn, connManager, upgrader))
+	t.Cleanup(server.Close)
+	laptopWS := dialWS(t, server, laptop)
+	phoneWS := dialWS(t, server, phone)
+	time.Sleep(50 * time.Millisecond)
+
+	handler := SessionsHandler(dbConn, connManager)
+	rec = doRequest(t, handler, laptop, "GET", "/api/sessions", "")
+	var sessions []models.SessionInfo
+	json.NewDecoder(rec.Body).Decode(&sessions)
+	if len(sessions) != 2 {
+		t.Fatalf("listed %d sessions, want 2", len(sessions))
+	}
+	var phoneID string
+	for _, s := range sessions {
+		if s.ID == phone.Value || s.ID == laptop.Value {
+			t.Fatal("session listing exposes cookie values")
+		}
+		if s.UserAgent == "phone-browser" {
+			phoneID = s.ID
+			if s.Current {
+				t.Error("phone session marked as current")
+			}
+		}
+	}
+	if phoneID == "" {
+		t.Fatalf("phone session not listed: %+v", sessions)
+	}
+
+	// Bob can't revoke Alice's sessions
+	bob := newTestUser(t, dbConn, "bob-id", "bob")
+	if rec := doRequest(t, handler, bob, "DELETE", "/api/sessions?id="+phoneID, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("revoking another user's session: status %d, want 404", rec.Code)
+	}
+
+	if rec := doRequest(t, handler, laptop, "DELETE", "/api/sessions?others=1", ""); rec.Code != http.StatusOK {
+		t.Fatalf("revoke others: status %d: %s", rec.Code, rec.Body)
+	}
+
+	phoneWS.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := phoneWS.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Errorf("phone WebSocket ended with %v, want a normal close", err)
+			}
+			break
+		}
+	}
+	if rec := doRequest(t, handler, phone, "GET", "/api/sessions", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("revoked session still works: status %d", rec.Code)
+	}
+
+	// The current session and its connection survive
+	if err := laptopWS.WriteJSON(map[string]interface{}{"type": "typing", "chatId": 0}); err != nil {
+		t.Fatalf("laptop WebSocket closed: %v", err)
+	}
+	if frame := readFrame(t, laptopWS); frame["type"] != "error" {
+		t.Errorf("laptop WebSocket got %v, want an error frame for the bogus chat", frame)
+	}
+}
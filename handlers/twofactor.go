@@ -0,0 +1,437 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TOTP parameters (RFC 6238). These are the defaults every authenticator app
+// understands, so they are spelled out in the provisioning URI but not configurable.
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	totpSkew   = 1 // accepted steps either side of now, for clock drift
+)
+
+const (
+	recoveryCodeCount       = 10
+	maxSecondFactorAttempts = 5
+)
+
+// TOTPIssuer is the account label shown in authenticator apps
+var TOTPIssuer = "Real-Time Forum"
+
+// PendingLoginTTL is how long a password-checked login waits for its second factor
+var PendingLoginTTL = 5 * time.Minute
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret returns a random 160-bit secret, base32-encoded as authenticator apps expect
+func newTOTPSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(buf), nil
+}
+
+// totpURI builds the otpauth:// provisioning URI, usually shown as a QR code
+func totpURI(secret, account string) string {
+	params := url.Values{
+		"secret":    {secret},
+		"issuer":    {TOTPIssuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	return "otpauth://totp/" + url.PathEscape(TOTPIssuer+":"+account) + "?" + params.Encode()
+}
+
+// totpCode computes the HOTP value (RFC 4226) of key for a time step
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// totpMatch returns the time step code is valid for at now, or -1 if it matches none
+func totpMatch(secret, code string, now time.Time) int64 {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return -1
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step
+		}
+	}
+	return -1
+}
+
+// newRecoveryCodes returns fresh codes formatted for display, e.g. abcd-efgh-ijkl-mnop
+func newRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, 10)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(totpEncoding.EncodeToString(buf))
+		codes[i] = raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16]
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode drops the separators and case a user may type
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// checkSecondFactor accepts a current TOTP code or an unused recovery code.
+// Both are single-use: a TOTP step can't be replayed and a recovery code is
+// marked used.
+func checkSecondFactor(db *sql.DB, userID, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+
+	if len(code) == totpDigits {
+		var secret string
+		var lastStep int64
+		err := db.QueryRow(`
+			SELECT totp_secret, totp_last_step FROM users
+			WHERE id = ? AND totp_enabled_at IS NOT NULL`, userID,
+		).Scan(&secret, &lastStep)
+		if err == sql.ErrNoRows {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		step := totpMatch(secret, code, time.Now())
+		if step <= lastStep {
+			return false, nil
+		}
+		res, err := db.Exec(`UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`, step, userID, step)
+		if err != nil {
+			return false, err
+		}
+		n, _ := res.RowsAffected()
+		return n == 1, nil
+	}
+
+	res, err := db.Exec(`
+		UPDATE totp_recovery_codes SET used_at = ?
+		WHERE code_hash = ? AND user_id = ? AND used_at IS NULL`,
+		time.Now(), hashToken(normalizeRecoveryCode(code)), userID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// startPendingLogin answers a correct password for an account with 2FA enabled.
+// Instead of a session the client gets a short-lived token to present with the
+// second factor at /login/2fa.
+func startPendingLogin(db *sql.DB, w http.ResponseWriter, userID string, remember bool) {
+	token, hash, err := newToken()
+	if err != nil {
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	_, err = db.Exec(`INSERT INTO pending_logins (token_hash, user_id, expires_at, remember) VALUES (?, ?, ?, ?)`,
+		hash, userID, time.Now().Add(PendingLoginTTL), remember)
+	if err != nil {
+		log.Printf("Database error saving pending login: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"two_factor_required": true,
+		"pending_token":       token,
+		"expires_in":          int(PendingLoginTTL.Seconds()),
+	})
+}
+
+// SecondFactorLoginHandler exchanges a pending-2FA token and a TOTP or recovery
+// code for a session. A pending token is discarded after too many wrong codes,
+// and wrong codes count towards the account's login throttling.
+func SecondFactorLoginHandler(db *sql.DB, limiter *LoginLimiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		hash := hashToken(r.FormValue("pending_token"))
+		var userID, nickname string
+		var attempts int
+		var remember bool
+		err := db.QueryRow(`
+			SELECT p.user_id, u.nickname, p.attempts, p.remember
+			FROM pending_logins p
+			JOIN users u ON u.id = p.user_id
+			WHERE p.token_hash = ? AND p.expires_at > ?`,
+			hash, time.Now(),
+		).Scan(&userID, &nickname, &attempts, &remember)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Login expired, please sign in again", http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			log.Printf("Database error loading pending login: %v", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		ip := clientIP(r)
+		if wait := limiter.RetryAfter(userID, ip); wait > 0 {
+			writeTooManyAttempts(w, wait)
+			return
+		}
+
+		ok, err := checkSecondFactor(db, userID, r.FormValue("code"))
+		if err != nil {
+			log.Printf("Database error checking second factor: %v", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			recordLockouts(db, limiter.RecordFailure(userID, ip))
+			if attempts+1 >= maxSecondFactorAttempts {
+				db.Exec(`DELETE FROM pending_logins WHERE token_hash = ?`, hash)
+			} else {
+				db.Exec(`UPDATE pending_logins SET attempts = attempts + 1 WHERE token_hash = ?`, hash)
+			}
+			http.Error(w, "Invalid code", http.StatusUnauthorized)
+			return
+		}
+
+		limiter.RecordSuccess(userID)
+
+		// Only one request can consume the pending login
+		res, err := db.Exec(`DELETE FROM pending_logins WHERE token_hash = ?`, hash)
+		if err != nil {
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "Login expired, please sign in again", http.StatusUnauthorized)
+			return
+		}
+
+		sessionID, err := CreateSession(db, w, r, userID, nickname, remember)
+		if err != nil {
+			log.Printf("Session creation error: %v", err)
+			http.Error(w, "Failed to create session", http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("Login successful for user: %s, session: %s", nickname, sessionID)
+		fmt.Fprintln(w, "Login successful")
+	}
+}
+
+// TwoFactorSetupHandler starts enrollment: it stores a new unconfirmed secret and
+// returns it with its provisioning URI. 2FA is not enforced until confirmed.
+func TwoFactorSetupHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		session := GetSession(db, r)
+		if session == nil || session.ExpiresAt.Before(time.Now()) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		secret, err := newTOTPSecret()
+		if err != nil {
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		res, err := db.Exec(`UPDATE users SET totp_secret = ? WHERE id = ? AND totp_enabled_at IS NULL`, secret, session.UserID)
+		if err != nil {
+			log.Printf("Database error saving TOTP secret: %v", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"secret": secret,
+			"uri":    totpURI(secret, session.Nickname),
+		})
+	}
+}
+
+// TwoFactorConfirmHandler turns 2FA on once the user proves their authenticator
+// produces valid codes, and returns the recovery codes. They are shown only here;
+// the database keeps their hashes.
+func TwoFactorConfirmHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		session := GetSession(db, r)
+		if session == nil || session.ExpiresAt.Before(time.Now()) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var secret sql.NullString
+		var enabled bool
+		err := db.QueryRow(`SELECT totp_secret, totp_enabled_at IS NOT NULL FROM users WHERE id = ?`, session.UserID).
+			Scan(&secret, &enabled)
+		if err != nil {
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		if enabled {
+			http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+			return
+		}
+		if !secret.Valid {
+			http.Error(w, "Start two-factor setup first", http.StatusBadRequest)
+			return
+		}
+
+		step := totpMatch(secret.String, strings.TrimSpace(r.FormValue("code")), time.Now())
+		if step < 0 {
+			http.Error(w, "Invalid code", http.StatusBadRequest)
+			return
+		}
+
+		codes, err := enableTwoFactor(db, session.UserID, step)
+		if err != nil {
+			log.Printf("Error enabling two-factor authentication: %v", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"enabled":        true,
+			"recovery_codes": codes,
+		})
+	}
+}
+
+// enableTwoFactor marks 2FA confirmed and replaces the user's recovery codes
+func enableTwoFactor(db *sql.DB, userID string, step int64) ([]string, error) {
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE users SET totp_enabled_at = ?, totp_last_step = ? WHERE id = ?`, time.Now(), step, userID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM totp_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		_, err := tx.Exec(`INSERT INTO totp_recovery_codes (code_hash, user_id) VALUES (?, ?)`,
+			hashToken(normalizeRecoveryCode(code)), userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, tx.Commit()
+}
+
+// TwoFactorDisableHandler turns 2FA off after the user re-enters their password
+func TwoFactorDisableHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		session := GetSession(db, r)
+		if session == nil || session.ExpiresAt.Before(time.Now()) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var passwordHash string
+		if err := db.QueryRow(`SELECT password_hash FROM users WHERE id = ?`, session.UserID).Scan(&passwordHash); err != nil {
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(r.FormValue("password"))) != nil {
+			http.Error(w, "Incorrect password", http.StatusForbidden)
+			return
+		}
+
+		if err := disableTwoFactor(db, session.UserID); err != nil {
+			log.Printf("Error disabling two-factor authentication: %v", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Fprintln(w, "Two-factor authentication disabled")
+	}
+}
+
+// disableTwoFactor clears the secret along with any recovery codes and pending logins
+func disableTwoFactor(db *sql.DB, userID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0 WHERE id = ?`, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM totp_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM pending_logins WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
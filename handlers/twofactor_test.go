@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestTOTPCodeMatchesRFC6238Vector(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1 secret, truncated to six digits
+	key := []byte("12345678901234567890")
+	cases := map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924"}
+	for unix, want := range cases {
+		if got := totpCode(key, unix/totpPeriod); got != want {
+			t.Errorf("totpCode at %d = %s, want %s", unix, got, want)
+		}
+	}
+}
+
+// postFormWithCookie runs handler against a form-encoded POST as the cookie's user
+func postFormWithCookie(handler http.HandlerFunc, cookie *http.Cookie, values url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	dbConn := newTestDB(t)
+	alice := newTestUser(t, dbConn, "alice-id", "alice")
+	limiter := NewLoginLimiter(nil)
+	limiter.Account.BaseDelay = 0
+	hash, _ := bcrypt.GenerateFromPassword([]byte("s3cret-pass"), bcrypt.MinCost)
+	dbConn.Exec(`UPDATE users SET password_hash = ? WHERE id = 'alice-id'`, string(hash))
+
+	rec := postFormWithCookie(TwoFactorSetupHandler(dbConn), alice, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("setup: status %d: %s", rec.Code, rec.Body)
+	}
+	var setup struct{ Secret, URI string }
+	json.NewDecoder(rec.Body).Decode(&setup)
+	if !strings.HasPrefix(setup.URI, "otpauth://totp/") || !strings.Contains(setup.URI, "secret="+setup.Secret) {
+		t.Errorf("unexpected provisioning URI %q", setup.URI)
+	}
+
+	key, _ := totpEncoding.DecodeString(setup.Secret)
+	code := func(at time.Time) string { return totpCode(key, at.Unix()/totpPeriod) }
+
+	// Not enforced until confirmed
+	login := url.Values{"loginType": {"nickname"}, "nickname": {"alice"}, "password": {"s3cret-pass"}}
+	if rec := postForm(LoginHandler(dbConn, limiter), login); rec.Code != http.StatusOK {
+		t.Fatalf("login before confirm: status %d, want 200", rec.Code)
+	}
+
+	if rec := postFormWithCookie(TwoFactorConfirmHandler(dbConn), alice, url.Values{"code": {"000000x"}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("confirm with bad code: status %d, want 400", rec.Code)
+	}
+	// The current step is spent by confirming, so log in with the previous one later
+	rec = postFormWithCookie(TwoFactorConfirmHandler(dbConn), alice, url.Values{"code": {code(time.Now().Add(-totpPeriod * time.Second))}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("confirm: status %d: %s", rec.Code, rec.Body)
+	}
+	var confirmed struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	json.NewDecoder(rec.Body).Decode(&confirmed)
+	if len(confirmed.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(confirmed.RecoveryCodes), recoveryCodeCount)
+	}
+
+	pending := func() string {
+		t.Helper()
+		rec := postForm(LoginHandler(dbConn, limiter), login)
+		if rec.Code != http.StatusAccepted || len(rec.Result().Cookies()) != 0 {
+			t.Fatalf("login with 2FA: status %d, cookies %v; want 202 and no session", rec.Code, rec.Result().Cookies())
+		}
+		var body struct {
+			PendingToken string `json:"pending_token"`
+		}
+		json.NewDecoder(rec.Body).Decode(&body)
+		return body.PendingToken
+	}
+
+	token := pending()
+	if rec := postForm(SecondFactorLoginHandler(dbConn, limiter), url.Values{"pending_token": {token}, "code": {"123456"}}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong code: status %d, want 401", rec.Code)
+	}
+	rec = postForm(SecondFactorLoginHandler(dbConn, limiter), url.Values{"pending_token": {token}, "code": {code(time.Now())}})
+	if rec.Code != http.StatusOK || len(rec.Result().Cookies()) == 0 {
+		t.Fatalf("second step: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := postForm(SecondFactorLoginHandler(dbConn, limiter), url.Values{"pending_token": {token}, "code": {code(time.Now())}}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("reusing pending token: status %d, want 401", rec.Code)
+	}
+
+	// Recovery codes work once, whatever the case and separators
+	recovery := strings.ToUpper(strings.ReplaceAll(confirmed.RecoveryCodes[0], "-", ""))
+	if rec := postForm(SecondFactorLoginHandler(dbConn, limiter), url.Values{"pending_token": {pending()}, "code": {recovery}}); rec.Code != http.StatusOK {
+		t.Errorf("recovery code: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := postForm(SecondFactorLoginHandler(dbConn, limiter), url.Values{"pending_token": {pending()}, "code": {recovery}}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("reused recovery code: status %d, want 401", rec.Code)
+	}
+
+	if rec := postFormWithCookie(TwoFactorDisableHandler(dbConn), alice, url.Values{"password": {"wrong"}}); rec.Code != http.StatusForbidden {
+		t.Errorf("disable with wrong password: status %d, want 403", rec.Code)
+	}
+	if rec := postFormWithCookie(TwoFactorDisableHandler(dbConn), alice, url.Values{"password": {"s3cret-pass"}}); rec.Code != http.StatusOK {
+		t.Fatalf("disable: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := postForm(LoginHandler(dbConn, limiter), login); rec.Code != http.StatusOK {
+		t.Errorf("login after disable: status %d, want 200", rec.Code)
+	}
+}
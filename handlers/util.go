@@ -36,8 +36,8 @@ func processAndValidateUser(firstName, lastName, nickname, ageStr, gender, email
 	if !regexp.MustCompile(`^[a-zA-Z0-9._-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`).MatchString(email) {
 		return nil, fmt.Errorf("invalid email format")
 	}
-	if len(password) < 8 || strings.ToLower(password) == "password" || password != confirmPassword {
-		return nil, fmt.Errorf("passwords do not match or are too weak")
+	if err := validatePassword(password, confirmPassword); err != nil {
+		return nil, err
 	}
 	if exists, _ := checkExists(db, "email", email); exists {
 		return nil, fmt.Errorf("email already registered")
@@ -65,6 +65,14 @@ func processAndValidateUser(firstName, lastName, nickname, ageStr, gender, email
 	}, nil
 }
 
+// validatePassword applies the password rules shared by signup and password reset
+func validatePassword(password, confirmPassword string) error {
+	if len(password) < 8 || strings.ToLower(password) == "password" || password != confirmPassword {
+		return fmt.Errorf("passwords do not match or are too weak")
+	}
+	return nil
+}
+
 func checkExists(db *sql.DB, field, value string) (bool, error) {
 	var count int
 	query := fmt.Sprintf("SELECT COUNT(*) FROM users WHERE %s = ?", field)
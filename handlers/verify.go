@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"real-time-forum/mailer"
+	"real-time-forum/models"
+)
+
+// EmailVerificationTTL is how long an email verification link stays valid
+var EmailVerificationTTL = 48 * time.Hour
+
+// VerificationSecret signs email verification links. main replaces it with
+// FORUM_SECRET; the random default means links stop working after a restart.
+var VerificationSecret = randomSecret()
+
+// Actions an account with an unverified email can be allowed to take. Reading is always allowed.
+const (
+	ActionPost    = "post"
+	ActionComment = "comment"
+	ActionVote    = "vote"
+	ActionChat    = "chat"
+	ActionReport  = "report"
+)
+
+// UnverifiedAllowed lists the actions open to unverified accounts. Empty by
+// default, so unverified users can read but not post, comment, vote, chat or report.
+var UnverifiedAllowed = map[string]bool{}
+
+var errInvalidVerification = errors.New("verification link is invalid or has expired")
+
+func randomSecret() []byte {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return buf
+}
+
+// canPerform reports whether the session may take action under the verification policy
+func canPerform(session *models.Session, action string) bool {
+	return session.EmailVerified || UnverifiedAllowed[action]
+}
+
+// isEmailVerified looks up the current verification state of a user
+func isEmailVerified(db *sql.DB, userID string) bool {
+	var verified bool
+	err := db.QueryRow(`SELECT email_verified_at IS NOT NULL FROM users WHERE id = ?`, userID).Scan(&verified)
+	return err == nil && verified
+}
+
+// requireVerified writes a 403 and returns false if the verification policy forbids action
+func requireVerified(w http.ResponseWriter, session *models.Session, action string) bool {
+	if canPerform(session, action) {
+		return true
+	}
+	http.Error(w, "Please verify your email address first", http.StatusForbidden)
+	return false
+}
+
+// signVerification returns payload followed by its HMAC, both base64url-encoded
+func signVerification(payload string) string {
+	mac := hmac.New(sha256.New, VerificationSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// openVerification checks the signature of a link token and returns its payload
+func openVerification(token string) (string, error) {
+	encodedPayload, encodedSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", errInvalidVerification
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return "", errInvalidVerification
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return "", errInvalidVerification
+	}
+	mac := hmac.New(sha256.New, VerificationSecret)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return "", errInvalidVerification
+	}
+	return string(payload), nil
+}
+
+// sendVerificationEmail records a new verification nonce for the user, replacing
+// any earlier one so only the latest link works, and mails the signed link
+func sendVerificationEmail(db *sql.DB, mail mailer.Mailer, userID, nickname, email string) error {
+	nonce, _, err := newToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(EmailVerificationTTL)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM email_verifications WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO email_verifications (nonce, user_id, email, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		nonce, userID, email, expiresAt, time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	token := signVerification(strings.Join([]string{nonce, userID, strconv.FormatInt(expiresAt.Unix(), 10)}, "|"))
+	link := BaseURL + "/api/verify-email?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Hi %s,\n\nWelcome to the forum! Please confirm your email address by opening this link "+
+		"within %s:\n\n%s\n", nickname, EmailVerificationTTL, link)
+	return mail.Send(email, "Confirm your forum email address", body)
+}
+
+// verifyEmail checks a link token against its stored nonce and marks the address
+// verified. The nonce is bound to the address it was sent to, so changing the
+// email invalidates older links.
+func verifyEmail(db *sql.DB, token string) error {
+	payload, err := openVerification(token)
+	if err != nil {
+		return err
+	}
+	parts := strings.Split(payload, "|")
+	if len(parts) != 3 {
+		return errInvalidVerification
+	}
+	nonce, userID := parts[0], parts[1]
+	expiresUnix, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || time.Now().After(time.Unix(expiresUnix, 0)) {
+		return errInvalidVerification
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		UPDATE users SET email_verified_at = ?
+		WHERE id = ? AND email = (
+			SELECT email FROM email_verifications WHERE nonce = ? AND user_id = ? AND expires_at > ?
+		)`,
+		time.Now(), userID, nonce, userID, time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errInvalidVerification
+	}
+	if _, err := tx.Exec(`DELETE FROM email_verifications WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// VerifyEmailHandler is the target of the link in the verification email
+func VerifyEmailHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		err := verifyEmail(db, r.URL.Query().Get("token"))
+		if err == errInvalidVerification {
+			http.Error(w, "Verification link is invalid or has expired", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Printf("Error verifying email: %v", err)
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/#posts", http.StatusSeeOther)
+	}
+}
+
+// ResendVerificationHandler mails a fresh verification link to the logged-in
+// user, throttled per address and per client IP by limiter
+func ResendVerificationHandler(db *sql.DB, mail mailer.Mailer, limiter *LoginLimiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		session := GetSession(db, r)
+		if session == nil || session.ExpiresAt.Before(time.Now()) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if session.EmailVerified {
+			http.Error(w, "Email already verified", http.StatusConflict)
+			return
+		}
+
+		var email string
+		if err := db.QueryRow(`SELECT email FROM users WHERE id = ?`, session.UserID).Scan(&email); err != nil {
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		account, ip := strings.ToLower(email), clientIP(r)
+		if wait := limiter.RetryAfter(account, ip); wait > 0 {
+			writeTooManyEmails(w, wait)
+			return
+		}
+		limiter.RecordFailure(account, ip)
+		if err := sendVerificationEmail(db, mail, session.UserID, session.Nickname, email); err != nil {
+			log.Printf("Error sending verification email: %v", err)
+			http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Fprintln(w, "Verification email sent")
+	}
+}
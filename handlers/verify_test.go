@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+)
+
+func TestUnverifiedAccountsCanReadButNotPost(t *testing.T) {
+	dbConn := newTestDB(t)
+	mail := &recordingMailer{}
+
+	signup := url.Values{
+		"firstName": {"Carol"}, "lastName": {"Test"}, "nickname": {"carol"}, "age": {"30"},
+		"gender": {"other"}, "email": {"carol@example.com"},
+		"password": {"s3cret-pass"}, "confirmPassword": {"s3cret-pass"},
+	}
+	if rec := postForm(SignupHandler(dbConn, mail), signup); rec.Code != http.StatusCreated {
+		t.Fatalf("signup: status %d: %s", rec.Code, rec.Body)
+	}
+	if len(mail.to) != 1 || mail.to[0] != "carol@example.com" {
+		t.Fatalf("mail sent to %v, want carol", mail.to)
+	}
+
+	var userID string
+	dbConn.QueryRow(`SELECT id FROM users WHERE nickname = 'carol'`).Scan(&userID)
+	rec := httptest.NewRecorder()
+	if _, err := CreateSession(dbConn, rec, httptest.NewRequest("POST", "/login", nil), userID, "carol", false); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	cookie := rec.Result().Cookies()[0]
+
+	posts := PostsHandler(dbConn)
+	if rec := doRequest(t, posts, cookie, "GET", "/api/posts", ""); rec.Code != http.StatusOK {
+		t.Errorf("unverified read: status %d, want 200", rec.Code)
+	}
+	if rec := doRequest(t, posts, cookie, "POST", "/api/posts", `{"title":"Hi","content":"first"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("unverified post: status %d, want 403", rec.Code)
+	}
+
+	// Resending is allowed once, then throttled
+	resend := ResendVerificationHandler(dbConn, mail, NewEmailLimiter(nil))
+	if rec := doRequest(t, resend, cookie, "POST", "/api/verify-email/resend", ""); rec.Code != http.StatusOK {
+		t.Fatalf("resend: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := doRequest(t, resend, cookie, "POST", "/api/verify-email/resend", ""); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("second resend: status %d, want 429", rec.Code)
+	}
+	if len(mail.to) != 2 {
+		t.Fatalf("sent %d emails, want 2", len(mail.to))
+	}
+
+	latest := mail.bodies[len(mail.bodies)-1]
+	match := regexp.MustCompile(`token=(\S+)`).FindStringSubmatch(latest)
+	if match == nil {
+		t.Fatalf("no token in mail body: %s", latest)
+	}
+	token, _ := url.QueryUnescape(match[1])
+
+	if rec := doRequest(t, VerifyEmailHandler(dbConn), nil, "GET", "/api/verify-email?token=x"+url.QueryEscape(token), ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("tampered token: status %d, want 400", rec.Code)
+	}
+	if rec := doRequest(t, VerifyEmailHandler(dbConn), nil, "GET", "/api/verify-email?token="+url.QueryEscape(token), ""); rec.Code != http.StatusSeeOther {
+		t.Fatalf("verify: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := doRequest(t, posts, cookie, "POST", "/api/posts", `{"title":"Hi","content":"first"}`); rec.Code != http.StatusCreated {
+		t.Errorf("verified post: status %d, want 201: %s", rec.Code, rec.Body)
+	}
+	if rec := doRequest(t, VerifyEmailHandler(dbConn), nil, "GET", "/api/verify-email?token="+url.QueryEscape(token), ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("reusing link: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// voteValues maps the vote names used by the API to the values stored in post_votes
+var voteValues = map[string]int{
+	"like":    1,
+	"dislike": -1,
+}
+
+// voteName converts a stored vote value back to its API name ("" means no vote)
+func voteName(value int) string {
+	switch value {
+	case 1:
+		return "like"
+	case -1:
+		return "dislike"
+	}
+	return ""
+}
+
+// VotePostHandler toggles or switches the caller's like/dislike on a post
+func VotePostHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		session := GetSession(db, r)
+		if session == nil || session.ExpiresAt.Before(time.Now()) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !requireVerified(w, session, ActionVote) {
+			return
+		}
+
+		var requestData struct {
+			PostID string `json:"post_id"`
+			Type   string `json:"type"` // "like" or "dislike"
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+			http.Error(w, "Invalid vote data", http.StatusBadRequest)
+			return
+		}
+
+		value, ok := voteValues[requestData.Type]
+		if requestData.PostID == "" || !ok {
+			http.Error(w, "Post ID and a vote type of like or dislike are required", http.StatusBadRequest)
+			return
+		}
+
+		if _, err := loadPostState(db, session, requestData.PostID); err != nil {
+			http.Error(w, "Post not found", http.StatusNotFound)
+			return
+		}
+
+		likes, dislikes, current, err := applyVote(db, session.UserID, requestData.PostID, value)
+		if err != nil {
+			log.Printf("Error applying vote: %v", err)
+			http.Error(w, "Failed to save vote", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"post_id":       requestData.PostID,
+			"like_count":    likes,
+			"dislike_count": dislikes,
+			"user_vote":     voteName(current),
+		})
+	}
+}
+
+// applyVote records the vote and refreshes the cached counters on posts in one
+// transaction. Casting the same vote twice removes it, casting the opposite vote
+// switches it. The counters are recomputed from post_votes rather than
+// incremented so concurrent voters can never leave them out of step.
+func applyVote(db *sql.DB, userID, postID string, value int) (likes, dislikes, current int, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer tx.Rollback()
+
+	// Start with a write so the transaction takes SQLite's write lock up front
+	res, err := tx.Exec(`
+		DELETE FROM post_votes WHERE user_id = ? AND post_id = ? AND value = ?`,
+		userID, postID, value,
+	)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	if removed, _ := res.RowsAffected(); removed == 0 {
+		_, err = tx.Exec(`
+			INSERT INTO post_votes (user_id, post_id, value, created_at)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT(user_id, post_id) DO UPDATE SET value = excluded.value, created_at = excluded.created_at`,
+			userID, postID, value, time.Now(),
+		)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		current = value
+	}
+
+	_, err = tx.Exec(`
+		UPDATE posts SET
+			likes = (SELECT COUNT(*) FROM post_votes WHERE post_id = ? AND value = 1),
+			dislikes = (SELECT COUNT(*) FROM post_votes WHERE post_id = ? AND value = -1)
+		WHERE id = ?`,
+		postID, postID, postID,
+	)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	err = tx.QueryRow(`SELECT likes, dislikes FROM posts WHERE id = ?`, postID).Scan(&likes, &dislikes)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, 0, 0, err
+	}
+	return likes, dislikes, current, nil
+}
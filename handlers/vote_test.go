@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// castVote posts a vote and returns the decoded response
+func castVote(t *testing.T, dbConn *sql.DB, cookie *http.Cookie, postID, voteType string) (likes, dislikes int, userVote string) {
+	t.Helper()
+	rec := doRequest(t, VotePostHandler(dbConn), cookie, "POST", "/api/posts/vote", fmt.Sprintf(`{"post_id":%q,"type":%q}`, postID, voteType))
+	if rec.Code != http.StatusOK {
+		t.Errorf("vote %s: status %d: %s", voteType, rec.Code, rec.Body)
+		return
+	}
+	var body struct {
+		LikeCount    int    `json:"like_count"`
+		DislikeCount int    `json:"dislike_count"`
+		UserVote     string `json:"user_vote"`
+	}
+	json.NewDecoder(rec.Body).Decode(&body)
+	return body.LikeCount, body.DislikeCount, body.UserVote
+}
+
+func TestVoteTogglesAndSwitches(t *testing.T) {
+	dbConn := newTestDB(t)
+	newTestUser(t, dbConn, "alice-id", "alice")
+	bob := newTestUser(t, dbConn, "bob-id", "bob")
+	if _, err := dbConn.Exec(`INSERT INTO posts (id, user_id, title, content) VALUES ('p1', 'alice-id', 't', 'c')`); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	for _, step := range []struct {
+		vote            string
+		likes, dislikes int
+		userVote        string
+	}{
+		{"like", 1, 0, "like"},
+		{"like", 0, 0, ""},
+		{"like", 1, 0, "like"},
+		{"dislike", 0, 1, "dislike"},
+		{"dislike", 0, 0, ""},
+	} {
+		likes, dislikes, userVote := castVote(t, dbConn, bob, "p1", step.vote)
+		if likes != step.likes || dislikes != step.dislikes || userVote != step.userVote {
+			t.Errorf("%s: got %d/%d %q, want %d/%d %q", step.vote, likes, dislikes, userVote, step.likes, step.dislikes, step.userVote)
+		}
+	}
+
+	if rec := doRequest(t, VotePostHandler(dbConn), bob, "POST", "/api/posts/vote", `{"post_id":"p1","type":"love"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown vote type: status %d, want 400", rec.Code)
+	}
+	if rec := doRequest(t, VotePostHandler(dbConn), bob, "POST", "/api/posts/vote", `{"post_id":"nope","type":"like"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("vote on missing post: status %d, want 404", rec.Code)
+	}
+}
+
+func TestConcurrentVotesKeepCountersInStep(t *testing.T) {
+	dbConn := newTestDB(t)
+	newTestUser(t, dbConn, "alice-id", "alice")
+	if _, err := dbConn.Exec(`INSERT INTO posts (id, user_id, title, content) VALUES ('p1', 'alice-id', 't', 'c')`); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	var voters []*http.Cookie
+	for i := 0; i < 8; i++ {
+		voters = append(voters, newTestUser(t, dbConn, fmt.Sprintf("voter-%d", i), fmt.Sprintf("voter%d", i)))
+	}
+
+	// Every voter likes, dislikes and likes again, all at once
+	var wg sync.WaitGroup
+	for i, cookie := range voters {
+		wg.Add(1)
+		go func(i int, cookie *http.Cookie) {
+			defer wg.Done()
+			for _, vote := range []string{"like", "dislike", "like"} {
+				castVote(t, dbConn, cookie, "p1", vote)
+			}
+			if i%2 == 0 {
+				castVote(t, dbConn, cookie, "p1", "like") // toggles it off again
+			}
+		}(i, cookie)
+	}
+	wg.Wait()
+
+	var likes, dislikes, likeRows, dislikeRows int
+	dbConn.QueryRow(`SELECT likes, dislikes FROM posts WHERE id = 'p1'`).Scan(&likes, &dislikes)
+	dbConn.QueryRow(`SELECT COUNT(*) FROM post_votes WHERE post_id = 'p1' AND value = 1`).Scan(&likeRows)
+	dbConn.QueryRow(`SELECT COUNT(*) FROM post_votes WHERE post_id = 'p1' AND value = -1`).Scan(&dislikeRows)
+	if likes != likeRows || dislikes != dislikeRows {
+		t.Errorf("counters %d/%d, post_votes rows %d/%d", likes, dislikes, likeRows, dislikeRows)
+	}
+	if likeRows != 4 || dislikeRows != 0 {
+		t.Errorf("post_votes rows %d/%d, want 4/0", likeRows, dislikeRows)
+	}
+}
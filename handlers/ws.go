@@ -2,59 +2,256 @@ package handlers
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"real-time-forum/models"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-// WebSocket connection manager
+// WebSocket connection manager. Each user can hold several connections at once
+// (one per tab or device) and events are delivered to all of them.
 type ConnectionManager struct {
-	connections map[string]*websocket.Conn
-	mutex       sync.RWMutex
+	clients map[string]map[*client]bool
+	mutex   sync.RWMutex
 }
 
 func NewConnectionManager() *ConnectionManager {
 	return &ConnectionManager{
-		connections: make(map[string]*websocket.Conn),
+		clients: make(map[string]map[*client]bool),
 	}
 }
 
-func (cm *ConnectionManager) AddConnection(userID string, conn *websocket.Conn) {
+// addClient registers a connection and reports whether it is the user's first one
+func (cm *ConnectionManager) addClient(c *client) bool {
 	cm.mutex.Lock()
 	defer cm.mutex.Unlock()
-	cm.connections[userID] = conn
+	if cm.clients[c.userID] == nil {
+		cm.clients[c.userID] = make(map[*client]bool)
+	}
+	cm.clients[c.userID][c] = true
+	return len(cm.clients[c.userID]) == 1
 }
 
-func (cm *ConnectionManager) RemoveConnection(userID string) {
+// removeClient forgets a single connection; the user stays registered while
+// any of their other connections remain open. It reports whether this was the
+// user's last connection.
+func (cm *ConnectionManager) removeClient(c *client) bool {
 	cm.mutex.Lock()
 	defer cm.mutex.Unlock()
-	delete(cm.connections, userID)
+	conns, ok := cm.clients[c.userID]
+	if !ok || !conns[c] {
+		return false
+	}
+	delete(conns, c)
+	if len(conns) == 0 {
+		delete(cm.clients, c.userID)
+		return true
+	}
+	return false
+}
+
+// register adds a connection, sends it the current presence snapshot and
+// announces the user as online if this is their first connection
+func (cm *ConnectionManager) register(c *client) {
+	first := cm.addClient(c)
+
+	c.sendJSON(map[string]interface{}{
+		"type":  "presence_snapshot",
+		"users": cm.OnlineUsers(c.userID),
+	})
+
+	if first {
+		cm.Broadcast(map[string]interface{}{
+			"type":     "user_online",
+			"userId":   c.userID,
+			"nickname": c.nickname,
+		})
+	}
+}
+
+// unregister removes a connection and announces the user as offline once their last connection is gone
+func (cm *ConnectionManager) unregister(c *client) {
+	if cm.removeClient(c) {
+		cm.Broadcast(map[string]interface{}{
+			"type":     "user_offline",
+			"userId":   c.userID,
+			"nickname": c.nickname,
+		})
+	}
 }
 
-func (cm *ConnectionManager) GetConnection(userID string) (*websocket.Conn, bool) {
+// OnlineUsers lists every user with at least one open connection, except excludeID, ordered by nickname
+func (cm *ConnectionManager) OnlineUsers(excludeID string) []models.OnlineUser {
 	cm.mutex.RLock()
 	defer cm.mutex.RUnlock()
-	conn, exists := cm.connections[userID]
-	return conn, exists
+	users := []models.OnlineUser{}
+	for userID, conns := range cm.clients {
+		if userID == excludeID {
+			continue
+		}
+		for c := range conns {
+			users = append(users, models.OnlineUser{ID: userID, Nickname: c.nickname})
+			break
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return strings.ToLower(users[i].Nickname) < strings.ToLower(users[j].Nickname)
+	})
+	return users
 }
 
-func (cm *ConnectionManager) Broadcast(message interface{}) {
+// userClients returns a snapshot of the user's open connections
+func (cm *ConnectionManager) userClients(userID string) []*client {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	clients := make([]*client, 0, len(cm.clients[userID]))
+	for c := range cm.clients[userID] {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// sessionIDs returns the distinct sessions with open connections, keyed by user ID
+func (cm *ConnectionManager) sessionIDs() map[string][]string {
 	cm.mutex.RLock()
 	defer cm.mutex.RUnlock()
+	ids := make(map[string][]string, len(cm.clients))
+	for userID, conns := range cm.clients {
+		seen := map[string]bool{}
+		for c := range conns {
+			if !seen[c.sessionID] {
+				seen[c.sessionID] = true
+				ids[userID] = append(ids[userID], c.sessionID)
+			}
+		}
+	}
+	return ids
+}
+
+// CloseSessions disconnects the user's connections that were opened under any
+// of sessionIDs and returns how many were closed. Each connection's handler
+// unregisters it once its read loop ends.
+func (cm *ConnectionManager) CloseSessions(userID string, sessionIDs ...string) int {
+	revoked := make(map[string]bool, len(sessionIDs))
+	for _, id := range sessionIDs {
+		revoked[id] = true
+	}
+	closed := 0
+	for _, c := range cm.userClients(userID) {
+		if revoked[c.sessionID] {
+			c.close()
+			closed++
+		}
+	}
+	return closed
+}
+
+// SendToUser queues message on every connection the user has open
+func (cm *ConnectionManager) SendToUser(userID string, message interface{}) {
+	cm.deliver(cm.userClients(userID), message)
+}
+
+// Broadcast queues message on every open connection
+func (cm *ConnectionManager) Broadcast(message interface{}) {
+	cm.mutex.RLock()
+	var clients []*client
+	for _, conns := range cm.clients {
+		for c := range conns {
+			clients = append(clients, c)
+		}
+	}
+	cm.mutex.RUnlock()
+
+	cm.deliver(clients, message)
+}
 
-	for userID, conn := range cm.connections {
-		if err := conn.WriteJSON(message); err != nil {
-			log.Printf("Error broadcasting to user %s: %v", userID, err)
-			// Remove the connection from map
-			cm.mutex.RUnlock()
-			cm.RemoveConnection(userID)
-			cm.mutex.RLock()
+// deliver encodes message once and queues it without blocking. Clients whose
+// queue is full are disconnected rather than holding up the sender.
+func (cm *ConnectionManager) deliver(clients []*client, message interface{}) {
+	if len(clients) == 0 {
+		return
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error encoding WebSocket frame: %v", err)
+		return
+	}
+	var dropped []*client
+	for _, c := range clients {
+		if !c.enqueue(data) {
+			log.Printf("Send queue full for user %s, disconnecting", c.userID)
+			c.close()
+			dropped = append(dropped, c)
 		}
 	}
+	for _, c := range dropped {
+		cm.unregister(c)
+	}
+}
+
+// Error codes sent in "error" frames when a chat event is rejected
+const (
+	wsErrChatNotFound     = "chat_not_found"
+	wsErrNotChatMember    = "not_chat_member"
+	wsErrReceiverMismatch = "receiver_mismatch"
+	wsErrEmailUnverified  = "email_unverified"
+)
+
+// chatAuthError explains why a user may not act on a chat
+type chatAuthError struct {
+	Code    string
+	Message string
+}
+
+func (e *chatAuthError) Error() string {
+	return e.Message
+}
+
+// authorizeChatReceiver checks that senderID belongs to the chat and returns the
+// other participant. A non-empty claimedReceiverID must match that participant.
+func authorizeChatReceiver(db *sql.DB, chatID int, senderID, claimedReceiverID string) (string, error) {
+	user1, user2, err := getChatParticipants(db, chatID)
+	if err == sql.ErrNoRows {
+		return "", &chatAuthError{Code: wsErrChatNotFound, Message: "Chat not found"}
+	} else if err != nil {
+		return "", err
+	}
+
+	var receiverID string
+	switch senderID {
+	case user1:
+		receiverID = user2
+	case user2:
+		receiverID = user1
+	default:
+		return "", &chatAuthError{Code: wsErrNotChatMember, Message: "You are not a participant of this chat"}
+	}
+
+	if claimedReceiverID != "" && claimedReceiverID != receiverID {
+		return "", &chatAuthError{Code: wsErrReceiverMismatch, Message: "Receiver does not belong to this chat"}
+	}
+	return receiverID, nil
+}
+
+// writeChatAuthError reports a rejected chat event back to the sender as an "error" frame
+func writeChatAuthError(c *client, chatID int, err error) {
+	var authErr *chatAuthError
+	if !errors.As(err, &authErr) {
+		authErr = &chatAuthError{Code: "server_error", Message: "Server error"}
+	}
+	c.sendJSON(map[string]interface{}{
+		"type":    "error",
+		"code":    authErr.Code,
+		"message": authErr.Message,
+		"chatId":  chatID,
+	})
 }
 
 func HandleWebSocket(dbConn *sql.DB, connManager *ConnectionManager, upgrader websocket.Upgrader) http.HandlerFunc {
@@ -71,11 +268,16 @@ func HandleWebSocket(dbConn *sql.DB, connManager *ConnectionManager, upgrader we
 			log.Println("WebSocket upgrade error:", err)
 			return
 		}
-		defer conn.Close()
 
-		// Register the connection
-		connManager.AddConnection(session.UserID, conn)
-		defer connManager.RemoveConnection(session.UserID)
+		// Register the connection and hand all writes to its writer goroutine
+		c := newClient(conn, session.UserID, session.Nickname, session.ID)
+		go c.writePump()
+		connManager.register(c)
+		defer func() {
+			connManager.unregister(c)
+			c.close()
+		}()
+		c.prepareRead()
 
 		log.Printf("WebSocket connected for user: %s (%s)", session.Nickname, session.UserID)
 
@@ -89,6 +291,39 @@ func HandleWebSocket(dbConn *sql.DB, connManager *ConnectionManager, upgrader we
 
 			log.Printf("Received WebSocket message: %+v", msg)
 
+			// Every chat event is checked against the chats row; the receiver is
+			// always derived server-side rather than trusted from the client
+			switch msg.Type {
+			case "message", "typing", "stop_typing", "read":
+			default:
+				continue
+			}
+			// Chat events count as session activity. A session that expired or was
+			// revoked since the connection opened ends it.
+			if lookupSession(dbConn, session.ID) == nil {
+				c.sendJSON(map[string]interface{}{"type": "session_expired"})
+				break
+			}
+
+			// Sending is subject to the email verification policy; read receipts are not
+			if msg.Type != "read" && !canPerform(session, ActionChat) {
+				// The user may have verified since connecting
+				session.EmailVerified = isEmailVerified(dbConn, session.UserID)
+				if !canPerform(session, ActionChat) {
+					writeChatAuthError(c, msg.ChatID, &chatAuthError{
+						Code: wsErrEmailUnverified, Message: "Please verify your email address first",
+					})
+					continue
+				}
+			}
+
+			receiverID, err := authorizeChatReceiver(dbConn, msg.ChatID, session.UserID, msg.ReceiverID)
+			if err != nil {
+				log.Printf("Rejected %s for chat %d from %s: %v", msg.Type, msg.ChatID, session.UserID, err)
+				writeChatAuthError(c, msg.ChatID, err)
+				continue
+			}
+
 			switch msg.Type {
 			case "message":
 				if msg.Message == "" {
@@ -111,18 +346,9 @@ func HandleWebSocket(dbConn *sql.DB, connManager *ConnectionManager, upgrader we
 					"time":        time.Now().Format("2006-01-02 15:04:05"),
 				}
 
-				// To sender
-				if err := conn.WriteJSON(response); err != nil {
-					log.Printf("Error sending to sender: %v", err)
-				}
-
-				// To receiver
-				if receiverConn, ok := connManager.GetConnection(msg.ReceiverID); ok {
-					if err := receiverConn.WriteJSON(response); err != nil {
-						log.Printf("Error sending to receiver: %v", err)
-					}
-				}
-				log.Print(msg.ReceiverID)
+				// To every tab of the sender, including this one, and of the receiver
+				connManager.SendToUser(session.UserID, response)
+				connManager.SendToUser(receiverID, response)
 
 			case "typing":
 				typingMsg := map[string]interface{}{
@@ -131,23 +357,37 @@ func HandleWebSocket(dbConn *sql.DB, connManager *ConnectionManager, upgrader we
 					"senderId":   session.UserID,
 					"senderName": session.Nickname,
 				}
-				if receiverConn, ok := connManager.GetConnection(msg.ReceiverID); ok {
-					if err := receiverConn.WriteJSON(typingMsg); err != nil {
-						log.Printf("Error sending typing to receiver: %v", err)
-					}
+				connManager.SendToUser(receiverID, typingMsg)
+
+			case "read":
+				// Clamp the cursor to a message that actually exists in this chat
+				var lastReadID sql.NullInt64
+				err = dbConn.QueryRow(`SELECT MAX(id) FROM messages WHERE chat_id = ? AND id <= ?`,
+					msg.ChatID, msg.MessageID).Scan(&lastReadID)
+				if err != nil || !lastReadID.Valid {
+					continue
+				}
+
+				if err := markChatRead(dbConn, msg.ChatID, session.UserID, int(lastReadID.Int64)); err != nil {
+					log.Printf("Error saving read receipt: %v", err)
+					continue
 				}
 
+				readMsg := map[string]interface{}{
+					"type":      "read",
+					"chatId":    msg.ChatID,
+					"readerId":  session.UserID,
+					"messageId": lastReadID.Int64,
+				}
+				connManager.SendToUser(receiverID, readMsg)
+
 			case "stop_typing":
 				stopTypingMsg := map[string]interface{}{
 					"type":     "stop_typing",
 					"chatId":   msg.ChatID,
 					"senderId": session.UserID,
 				}
-				if receiverConn, ok := connManager.GetConnection(msg.ReceiverID); ok {
-					if err := receiverConn.WriteJSON(stopTypingMsg); err != nil {
-						log.Printf("Error sending stop_typing to receiver: %v", err)
-					}
-				}
+				connManager.SendToUser(receiverID, stopTypingMsg)
 			}
 		}
 	}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Time allowed to write a frame to the peer
+	wsWriteWait = 10 * time.Second
+
+	// Time allowed to read the next pong from the peer
+	wsPongWait = 60 * time.Second
+
+	// Pings are sent at this interval; must be shorter than wsPongWait
+	wsPingPeriod = (wsPongWait * 9) / 10
+
+	// Largest frame accepted from the peer
+	wsMaxMessageSize = 8192
+
+	// Outbound frames queued per connection before it counts as a slow consumer
+	wsSendBufferSize = 64
+)
+
+// client is one WebSocket connection. gorilla/websocket allows a single
+// concurrent writer, so everything sent to the connection goes through the
+// buffered send queue and is written by writePump alone.
+type client struct {
+	userID    string
+	nickname  string
+	sessionID string
+	conn      *websocket.Conn
+	send      chan []byte
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newClient(conn *websocket.Conn, userID, nickname, sessionID string) *client {
+	return &client{
+		userID:    userID,
+		nickname:  nickname,
+		sessionID: sessionID,
+		conn:      conn,
+		send:      make(chan []byte, wsSendBufferSize),
+		done:      make(chan struct{}),
+	}
+}
+
+// enqueue queues an already encoded frame without blocking. It reports false
+// when the queue is full or the client is closed.
+func (c *client) enqueue(data []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// sendJSON encodes message and queues it, closing the client if its queue has overflowed
+func (c *client) sendJSON(message interface{}) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error encoding WebSocket frame: %v", err)
+		return
+	}
+	if !c.enqueue(data) {
+		log.Printf("Send queue full for user %s, disconnecting", c.userID)
+		c.close()
+	}
+}
+
+// close stops the writer, which sends a close frame and closes the connection.
+// That in turn ends the read loop. Safe to call more than once.
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// writePump is the only goroutine that writes to the connection. It drains the
+// send queue and keeps the connection alive with pings.
+func (c *client) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case data := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				log.Printf("WebSocket write error for user %s: %v", c.userID, err)
+				c.close()
+				return
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.close()
+				return
+			}
+
+		case <-c.done:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return
+		}
+	}
+}
+
+// prepareRead applies the read limit and keeps extending the read deadline for as long as pongs arrive
+func (c *client) prepareRead() {
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		return nil
+	})
+}
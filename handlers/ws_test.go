@@ -0,0 +1,359 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"real-time-forum/db"
+
+	"github.com/gorilla/websocket"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newTestDB opens a fresh SQLite database with the forum schema applied
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dbConn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { dbConn.Close() })
+	db.InitializeSchema(dbConn)
+	return dbConn
+}
+
+// newTestUser inserts a user and returns a session cookie for them
+func newTestUser(t *testing.T, dbConn *sql.DB, id, nickname string) *http.Cookie {
+	t.Helper()
+	_, err := dbConn.Exec(`
+		INSERT INTO users (id, first_name, last_name, nickname, age, gender, email, password_hash, email_verified_at)
+		VALUES (?, 'Test', 'User', ?, 30, 'other', ?, 'x', CURRENT_TIMESTAMP)`,
+		id, nickname, nickname+"@example.com",
+	)
+	if err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	if _, err := CreateSession(dbConn, rec, httptest.NewRequest("POST", "/login", nil), id, nickname, false); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "session" {
+			return c
+		}
+	}
+	t.Fatal("no session cookie set")
+	return nil
+}
+
+// dialWS opens a WebSocket to the test server authenticated with the given cookie
+func dialWS(t *testing.T, server *httptest.Server, cookie *http.Cookie) *websocket.Conn {
+	t.Helper()
+	header := http.Header{}
+	header.Set("Cookie", cookie.String())
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("dial websocket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// isPresenceFrame reports whether frame is one of the hub's presence events
+func isPresenceFrame(frame map[string]interface{}) bool {
+	switch frame["type"] {
+	case "presence_snapshot", "user_online", "user_offline":
+		return true
+	}
+	return false
+}
+
+// readAnyFrame reads the next JSON frame, failing the test if none arrives in time
+func readAnyFrame(t *testing.T, conn *websocket.Conn) map[string]interface{} {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var frame map[string]interface{}
+	if err := conn.ReadJSON(&frame); err != nil {
+		t.Fatalf("read frame: %v", err)
+	}
+	return frame
+}
+
+// readFrame reads the next JSON frame that is not a presence event
+func readFrame(t *testing.T, conn *websocket.Conn) map[string]interface{} {
+	t.Helper()
+	for {
+		if frame := readAnyFrame(t, conn); !isPresenceFrame(frame) {
+			return frame
+		}
+	}
+}
+
+// expectNoFrame asserts that nothing but presence events arrives on conn for a short while
+func expectNoFrame(t *testing.T, conn *websocket.Conn) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	for {
+		var frame map[string]interface{}
+		if err := conn.ReadJSON(&frame); err != nil {
+			return
+		}
+		if !isPresenceFrame(frame) {
+			t.Fatalf("unexpected frame: %v", frame)
+		}
+	}
+}
+
+func countMessages(t *testing.T, dbConn *sql.DB, chatID int) int {
+	t.Helper()
+	var n int
+	if err := dbConn.QueryRow(`SELECT COUNT(*) FROM messages WHERE chat_id = ?`, chatID).Scan(&n); err != nil {
+		t.Fatalf("count messages: %v", err)
+	}
+	return n
+}
+
+type wsFixture struct {
+	db                  *sql.DB
+	alice, bob, mallory *websocket.Conn
+	aliceBobChat        int
+}
+
+func newWSFixture(t *testing.T) *wsFixture {
+	t.Helper()
+	dbConn := newTestDB(t)
+	aliceCookie := newTestUser(t, dbConn, "alice-id", "alice")
+	bobCookie := newTestUser(t, dbConn, "bob-id", "bob")
+	malloryCookie := newTestUser(t, dbConn, "mallory-id", "mallory")
+
+	chatID, err := findOrCreateChat(dbConn, "alice-id", "bob-id")
+	if err != nil {
+		t.Fatalf("create chat: %v", err)
+	}
+
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	server := httptest.NewServer(HandleWebSocket(dbConn, NewConnectionManager(), upgrader))
+	t.Cleanup(server.Close)
+
+	f := &wsFixture{
+		db:           dbConn,
+		alice:        dialWS(t, server, aliceCookie),
+		bob:          dialWS(t, server, bobCookie),
+		mallory:      dialWS(t, server, malloryCookie),
+		aliceBobChat: chatID,
+	}
+	// Give the server a moment to register all three connections
+	time.Sleep(50 * time.Millisecond)
+	return f
+}
+
+func TestWebSocketMessageDeliveredToDerivedReceiver(t *testing.T) {
+	f := newWSFixture(t)
+
+	// No receiverId: the server works it out from the chat
+	err := f.alice.WriteJSON(map[string]interface{}{
+		"type":    "message",
+		"chatId":  f.aliceBobChat,
+		"message": "hi bob",
+	})
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	for name, conn := range map[string]*websocket.Conn{"alice": f.alice, "bob": f.bob} {
+		frame := readFrame(t, conn)
+		if frame["type"] != "message" || frame["message"] != "hi bob" {
+			t.Errorf("%s got %v, want the message", name, frame)
+		}
+	}
+	expectNoFrame(t, f.mallory)
+
+	if n := countMessages(t, f.db, f.aliceBobChat); n != 1 {
+		t.Errorf("saved %d messages, want 1", n)
+	}
+}
+
+func TestWebSocketRejectsNonMember(t *testing.T) {
+	f := newWSFixture(t)
+
+	err := f.mallory.WriteJSON(map[string]interface{}{
+		"type":       "message",
+		"chatId":     f.aliceBobChat,
+		"receiverId": "bob-id",
+		"message":    "injected",
+	})
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	frame := readFrame(t, f.mallory)
+	if frame["type"] != "error" || frame["code"] != wsErrNotChatMember {
+		t.Errorf("got %v, want %s error", frame, wsErrNotChatMember)
+	}
+	expectNoFrame(t, f.bob)
+
+	if n := countMessages(t, f.db, f.aliceBobChat); n != 0 {
+		t.Errorf("saved %d messages, want 0", n)
+	}
+}
+
+func TestWebSocketRejectsSpoofedReceiver(t *testing.T) {
+	f := newWSFixture(t)
+
+	err := f.alice.WriteJSON(map[string]interface{}{
+		"type":       "message",
+		"chatId":     f.aliceBobChat,
+		"receiverId": "mallory-id",
+		"message":    "misdirected",
+	})
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	frame := readFrame(t, f.alice)
+	if frame["type"] != "error" || frame["code"] != wsErrReceiverMismatch {
+		t.Errorf("got %v, want %s error", frame, wsErrReceiverMismatch)
+	}
+	expectNoFrame(t, f.mallory)
+	expectNoFrame(t, f.bob)
+
+	if n := countMessages(t, f.db, f.aliceBobChat); n != 0 {
+		t.Errorf("saved %d messages, want 0", n)
+	}
+}
+
+func TestWebSocketRejectsUnknownChat(t *testing.T) {
+	f := newWSFixture(t)
+
+	err := f.alice.WriteJSON(map[string]interface{}{
+		"type":       "typing",
+		"chatId":     9999,
+		"receiverId": "bob-id",
+	})
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	frame := readFrame(t, f.alice)
+	if frame["type"] != "error" || frame["code"] != wsErrChatNotFound {
+		t.Errorf("got %v, want %s error", frame, wsErrChatNotFound)
+	}
+	expectNoFrame(t, f.bob)
+}
+
+func TestWebSocketDeliversToEveryTab(t *testing.T) {
+	dbConn := newTestDB(t)
+	aliceCookie := newTestUser(t, dbConn, "alice-id", "alice")
+	bobCookie := newTestUser(t, dbConn, "bob-id", "bob")
+	chatID, err := findOrCreateChat(dbConn, "alice-id", "bob-id")
+	if err != nil {
+		t.Fatalf("create chat: %v", err)
+	}
+
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	server := httptest.NewServer(HandleWebSocket(dbConn, NewConnectionManager(), upgrader))
+	t.Cleanup(server.Close)
+
+	alice := dialWS(t, server, aliceCookie)
+	aliceOtherTab := dialWS(t, server, aliceCookie)
+	bobLaptop := dialWS(t, server, bobCookie)
+	bobPhone := dialWS(t, server, bobCookie)
+	time.Sleep(50 * time.Millisecond)
+
+	send := func(text string) {
+		t.Helper()
+		err := alice.WriteJSON(map[string]interface{}{"type": "message", "chatId": chatID, "message": text})
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	send("hello")
+	for name, conn := range map[string]*websocket.Conn{
+		"alice": alice, "alice other tab": aliceOtherTab, "bob laptop": bobLaptop, "bob phone": bobPhone,
+	} {
+		if frame := readFrame(t, conn); frame["message"] != "hello" {
+			t.Errorf("%s got %v, want the message", name, frame)
+		}
+	}
+
+	// Closing one of bob's connections must not unregister the other
+	bobLaptop.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	send("still there?")
+	if frame := readFrame(t, bobPhone); frame["message"] != "still there?" {
+		t.Errorf("bob phone got %v, want the message", frame)
+	}
+}
+
+func TestConnectionManagerDisconnectsSlowConsumer(t *testing.T) {
+	cm := NewConnectionManager()
+	slow := newClient(nil, "slow-id", "slow", "") // no writer pump, so nothing drains its queue
+	cm.addClient(slow)
+
+	for i := 0; i < wsSendBufferSize; i++ {
+		cm.SendToUser("slow-id", map[string]int{"n": i})
+	}
+	if len(cm.userClients("slow-id")) != 1 {
+		t.Fatal("client dropped before its queue was full")
+	}
+
+	// The next frame overflows the queue: the client is closed and unregistered instead of blocking
+	cm.SendToUser("slow-id", map[string]string{"type": "overflow"})
+
+	select {
+	case <-slow.done:
+	default:
+		t.Error("slow client was not closed")
+	}
+	if n := len(cm.userClients("slow-id")); n != 0 {
+		t.Errorf("slow client still registered (%d connections)", n)
+	}
+}
+
+func TestWebSocketPresenceEvents(t *testing.T) {
+	dbConn := newTestDB(t)
+	aliceCookie := newTestUser(t, dbConn, "alice-id", "alice")
+	bobCookie := newTestUser(t, dbConn, "bob-id", "bob")
+
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	server := httptest.NewServer(HandleWebSocket(dbConn, NewConnectionManager(), upgrader))
+	t.Cleanup(server.Close)
+
+	alice := dialWS(t, server, aliceCookie)
+	if frame := readAnyFrame(t, alice); frame["type"] != "presence_snapshot" || len(frame["users"].([]interface{})) != 0 {
+		t.Fatalf("alice got %v, want an empty presence_snapshot", frame)
+	}
+	if frame := readAnyFrame(t, alice); frame["type"] != "user_online" || frame["userId"] != "alice-id" {
+		t.Fatalf("alice got %v, want her own user_online", frame)
+	}
+
+	bob := dialWS(t, server, bobCookie)
+	snapshot := readAnyFrame(t, bob)
+	users, _ := snapshot["users"].([]interface{})
+	if snapshot["type"] != "presence_snapshot" || len(users) != 1 || users[0].(map[string]interface{})["id"] != "alice-id" {
+		t.Fatalf("bob got %v, want a snapshot listing alice", snapshot)
+	}
+	if frame := readAnyFrame(t, alice); frame["type"] != "user_online" || frame["userId"] != "bob-id" {
+		t.Fatalf("alice got %v, want user_online for bob", frame)
+	}
+
+	// A second tab is not a new arrival, and closing it is not a departure
+	bobPhone := dialWS(t, server, bobCookie)
+	readAnyFrame(t, bobPhone) // snapshot
+	bobPhone.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	bob.Close()
+	if frame := readAnyFrame(t, alice); frame["type"] != "user_offline" || frame["userId"] != "bob-id" {
+		t.Fatalf("alice got %v, want a single user_offline for bob", frame)
+	}
+}
@@ -1,23 +1,31 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"real-time-forum/db"
 	"real-time-forum/handlers"
+	"real-time-forum/mailer"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/websocket"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+	CheckOrigin: handlers.OriginAllowed,
 }
 var connManager = handlers.NewConnectionManager()
+var loginLimiter = newLoginLimiter()
+var emailLimiter = handlers.NewEmailLimiter(nil)
 
 func main() {
 	// Set up logging
@@ -34,42 +42,249 @@ func main() {
 	db.InitializeSchema(dbConn)
 	log.Println("Database schema initialized")
 
+	// Administration commands run instead of the server
+	if len(os.Args) > 1 {
+		if err := runCommand(dbConn, os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if admins, err := handlers.CountAdmins(dbConn); err == nil && admins == 0 {
+		log.Println("No admin yet; promote one with: go run -tags sqlite_fts5 main.go promote <nickname>")
+	}
+
+	// Cancelled on Ctrl+C or SIGTERM to stop background work and the server
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Purge expired sessions and tokens, e.g. FORUM_REAPER_INTERVAL=5m
+	reaper := handlers.NewReaper(dbConn, connManager, envDuration("FORUM_REAPER_INTERVAL", 5*time.Minute))
+	go reaper.Run(ctx)
+
+	if baseURL := os.Getenv("FORUM_BASE_URL"); baseURL != "" {
+		handlers.BaseURL = strings.TrimRight(baseURL, "/")
+	}
+	mail := newMailer()
+
+	if secret := os.Getenv("FORUM_SECRET"); secret != "" {
+		handlers.VerificationSecret = []byte(secret)
+	} else {
+		log.Println("FORUM_SECRET not set, verification links will stop working after a restart")
+	}
+
+	// What accounts with an unverified email may do besides reading, e.g. "comment,vote"
+	for _, action := range strings.Split(os.Getenv("FORUM_UNVERIFIED_ALLOW"), ",") {
+		if action = strings.TrimSpace(action); action != "" {
+			handlers.UnverifiedAllowed[action] = true
+		}
+	}
+
+	if issuer := os.Getenv("FORUM_TOTP_ISSUER"); issuer != "" {
+		handlers.TOTPIssuer = issuer
+	}
+
+	handlers.TrustProxyHeaders = os.Getenv("FORUM_TRUST_PROXY") == "1"
+
+	// Origins besides our own host allowed to post and open WebSockets, e.g. "https://forum.example.com"
+	for _, origin := range strings.Split(os.Getenv("FORUM_ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			handlers.AllowedOrigins[origin] = true
+		}
+	}
+
+	// Session lifetimes, e.g. FORUM_SESSION_IDLE=30m FORUM_SESSION_LIFETIME=12h
+	handlers.Sessions.IdleTimeout = envDuration("FORUM_SESSION_IDLE", handlers.Sessions.IdleTimeout)
+	handlers.Sessions.MaxLifetime = envDuration("FORUM_SESSION_LIFETIME", handlers.Sessions.MaxLifetime)
+	handlers.Sessions.RememberMeIdleTimeout = envDuration("FORUM_REMEMBER_ME_IDLE", handlers.Sessions.RememberMeIdleTimeout)
+	handlers.Sessions.RememberMeLifetime = envDuration("FORUM_REMEMBER_ME_LIFETIME", handlers.Sessions.RememberMeLifetime)
+	handlers.Sessions.SecureCookie = os.Getenv("FORUM_SECURE_COOKIES") == "1"
+
+	// Deepest comment reply level; 0 allows top-level comments only
+	handlers.MaxCommentDepth = envInt("FORUM_MAX_COMMENT_DEPTH", handlers.MaxCommentDepth)
+
 	// Set up static file server
 	fs := http.FileServer(http.Dir("./static"))
 	http.Handle("/", fs)
 
 	// Set up API routes with logging
-	http.HandleFunc("/signup", handlers.LoggingMiddleware(handlers.SignupHandler(dbConn)))
-	http.HandleFunc("/login", handlers.LoggingMiddleware(handlers.LoginHandler(dbConn)))
+	http.HandleFunc("/signup", handlers.LoggingMiddleware(handlers.SignupHandler(dbConn, mail)))
+	http.HandleFunc("/login", handlers.LoggingMiddleware(handlers.LoginHandler(dbConn, loginLimiter)))
+	http.HandleFunc("/login/2fa", handlers.LoggingMiddleware(handlers.SecondFactorLoginHandler(dbConn, loginLimiter)))
 
 	// Posts routes
 	http.HandleFunc("/api/posts", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.PostsHandler(dbConn))))
 
+	// Post voting route
+	http.HandleFunc("/api/posts/vote", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.VotePostHandler(dbConn))))
+
+	// Categories: public listing plus admin management
+	http.HandleFunc("/api/categories", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.CategoriesHandler(dbConn))))
+	http.HandleFunc("/api/admin/categories", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.RequireRole(dbConn, handlers.RoleAdmin, handlers.AdminCategoriesHandler(dbConn)))))
+	http.HandleFunc("/api/admin/categories/merge", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.RequireRole(dbConn, handlers.RoleAdmin, handlers.MergeCategoriesHandler(dbConn)))))
+
+	// Role management and reaper totals for admins
+	http.HandleFunc("/api/admin/roles", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.RequireRole(dbConn, handlers.RoleAdmin, handlers.AdminRolesHandler(dbConn)))))
+	http.HandleFunc("/api/admin/maintenance", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.RequireRole(dbConn, handlers.RoleAdmin, handlers.ReaperStatsHandler(dbConn, reaper)))))
+
+	// Post moderation for moderators, and its log for admins
+	http.HandleFunc("/api/moderation/posts", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.RequireRole(dbConn, handlers.RoleModerator, handlers.ModeratePostHandler(dbConn)))))
+	http.HandleFunc("/api/admin/moderation-log", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.RequireRole(dbConn, handlers.RoleAdmin, handlers.ModerationLogHandler(dbConn)))))
+
+	// Reports from users and the moderators' queue for them
+	http.HandleFunc("/api/reports", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.ReportsHandler(dbConn))))
+	http.HandleFunc("/api/moderation/reports", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.RequireRole(dbConn, handlers.RoleModerator, handlers.ReportQueueHandler(dbConn)))))
+
+	// Full-text search over posts and comments
+	http.HandleFunc("/api/search", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.SearchHandler(dbConn))))
+
 	// Post details route - NEW
 	http.HandleFunc("/api/post-details", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.GetPostWithComments(dbConn))))
 
-	// Comments route - NEW
-	http.HandleFunc("/api/comments", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.CreateComment(dbConn))))
+	// Comments route - create, edit and delete
+	http.HandleFunc("/api/comments", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.CommentsHandler(dbConn))))
+
+	// Email verification
+	http.HandleFunc("/api/verify-email", handlers.LoggingMiddleware(handlers.VerifyEmailHandler(dbConn)))
+	http.HandleFunc("/api/verify-email/resend", handlers.LoggingMiddleware(handlers.ResendVerificationHandler(dbConn, mail, emailLimiter)))
+
+	// Two-factor authentication enrollment
+	http.HandleFunc("/api/2fa/setup", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.TwoFactorSetupHandler(dbConn))))
+	http.HandleFunc("/api/2fa/confirm", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.TwoFactorConfirmHandler(dbConn))))
+	http.HandleFunc("/api/2fa/disable", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.TwoFactorDisableHandler(dbConn))))
+
+	// Password recovery
+	http.HandleFunc("/api/password/forgot", handlers.LoggingMiddleware(handlers.ForgotPasswordHandler(dbConn, mail, emailLimiter)))
+	http.HandleFunc("/api/password/reset", handlers.LoggingMiddleware(handlers.ResetPasswordHandler(dbConn)))
 
 	// Session management endpoints
 	http.HandleFunc("/api/check-auth", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.CheckAuthHandler(dbConn))))
 	http.HandleFunc("/api/logout", handlers.LoggingMiddleware(handlers.LogoutHandler(dbConn)))
+	http.HandleFunc("/api/sessions", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.SessionsHandler(dbConn, connManager))))
 
 	// Online users endpoint with activity tracking
-	http.HandleFunc("/api/online-users", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.OnlineUsersHandler(dbConn))))
-	http.HandleFunc("/api/users", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.OnlineUsersHandler(dbConn))))
+	http.HandleFunc("/api/online-users", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.OnlineUsersHandler(dbConn, connManager))))
+	http.HandleFunc("/api/users", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.OnlineUsersHandler(dbConn, connManager))))
 
 	// Current user endpoint with activity tracking
 	http.HandleFunc("/api/user/current", handlers.LoggingMiddleware(handlers.CurrentUserHandler(dbConn)))
 
 	// Chat endpoints with activity tracking
 	http.HandleFunc("/api/chat", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.HandleChatRequest(dbConn))))
-	http.HandleFunc("/api/chat/history", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.HandleChatHistory(dbConn))))
+	http.HandleFunc("/api/chat/history", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.HandleChatHistory(dbConn, connManager))))
+	http.HandleFunc("/api/chat/conversations", handlers.LoggingMiddleware(handlers.ActivityMiddleware(dbConn, handlers.HandleConversations(dbConn))))
 
 	// WebSocket endpoint - pass both connection manager and upgrader
 	http.HandleFunc("/ws", handlers.HandleWebSocket(dbConn, connManager, upgrader))
 
 	// Start server
+	// Every route goes through the CSRF checks; they only reject state-changing methods
+	server := &http.Server{Addr: ":8080", Handler: handlers.CSRFMiddleware(http.DefaultServeMux.ServeHTTP)}
+	go func() {
+		<-ctx.Done()
+		log.Println("Shutting down...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
 	fmt.Println("Server running on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+// runCommand handles command line administration:
+//
+//	promote <nickname> [role]   give a user the admin role, or the given role
+func runCommand(dbConn *sql.DB, args []string) error {
+	switch args[0] {
+	case "promote":
+		if len(args) < 2 || len(args) > 3 {
+			return fmt.Errorf("usage: promote <nickname> [user|moderator|admin]")
+		}
+		role := handlers.RoleAdmin
+		if len(args) == 3 {
+			role = args[2]
+		}
+		if err := handlers.SetRole(dbConn, args[1], role); err == sql.ErrNoRows {
+			return fmt.Errorf("no user with nickname %q", args[1])
+		} else if err != nil {
+			return err
+		}
+		fmt.Printf("%s is now %s\n", args[1], role)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// newMailer sends mail over SMTP when FORUM_SMTP_HOST is set and otherwise
+// writes it to FORUM_MAIL_LOG, or to the server log if that is unset too
+func newMailer() mailer.Mailer {
+	host := os.Getenv("FORUM_SMTP_HOST")
+	if host == "" {
+		log.Println("FORUM_SMTP_HOST not set, outgoing mail will be logged instead of sent")
+		return &mailer.LogMailer{Path: os.Getenv("FORUM_MAIL_LOG")}
+	}
+
+	port, err := strconv.Atoi(os.Getenv("FORUM_SMTP_PORT"))
+	if err != nil {
+		port = 587
+	}
+	from := os.Getenv("FORUM_MAIL_FROM")
+	if from == "" {
+		from = "forum@" + host
+	}
+	return &mailer.SMTPMailer{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("FORUM_SMTP_USER"),
+		Password: os.Getenv("FORUM_SMTP_PASSWORD"),
+		From:     from,
+	}
+}
+
+// newLoginLimiter applies the FORUM_LOGIN_* overrides to the default login throttling
+func newLoginLimiter() *handlers.LoginLimiter {
+	limiter := handlers.NewLoginLimiter(nil)
+	if n, err := strconv.Atoi(os.Getenv("FORUM_LOGIN_MAX_FAILURES")); err == nil && n > 0 {
+		limiter.Account.MaxFailures = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("FORUM_LOGIN_IP_MAX_FAILURES")); err == nil && n > 0 {
+		limiter.IP.MaxFailures = n
+	}
+	lockout := envDuration("FORUM_LOGIN_LOCKOUT", limiter.Account.Lockout)
+	limiter.Account.Lockout = lockout
+	limiter.IP.Lockout = lockout
+	return limiter
+}
+
+// envInt parses a non-negative integer from the environment, keeping fallback
+// when the variable is unset or invalid
+func envInt(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Printf("Ignoring invalid %s %q", name, value)
+		return fallback
+	}
+	return n
+}
+
+// envDuration parses a duration such as "15m" from the environment, keeping
+// fallback when the variable is unset or invalid
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Ignoring invalid %s %q", name, value)
+		return fallback
+	}
+	return d
 }
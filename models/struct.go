@@ -11,32 +11,116 @@ type User struct {
 	Gender       string
 	Email        string
 	PasswordHash string
+	Role         string
 }
 
 type Post struct {
-	ID           string    `json:"id"`
-	UserID       string    `json:"user_id"`
-	CategoryID   string    `json:"category_id"`
-	Title        string    `json:"title"`
-	Content      string    `json:"content"`
-	LikeCount    int       `json:"like_count"`
-	DislikeCount int       `json:"dislike_count"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID           string     `json:"id"`
+	UserID       string     `json:"user_id"`
+	CategoryID   string     `json:"category_id"`
+	Title        string     `json:"title"`
+	Content      string     `json:"content"`
+	LikeCount    int        `json:"like_count"`
+	DislikeCount int        `json:"dislike_count"`
+	UserVote     string     `json:"user_vote"` // caller's vote: "like", "dislike" or ""
+	CreatedAt    time.Time  `json:"created_at"`
+	EditedAt     *time.Time `json:"edited_at"` // nil until the author edits the post
+
+	AuthorNickname string    `json:"author_nickname"`
+	CommentCount   int       `json:"comment_count"`
+	LastActivityAt time.Time `json:"last_activity_at"` // latest of creation, edit and newest comment
+
+	Pinned       bool   `json:"pinned"`
+	Locked       bool   `json:"locked"`
+	Hidden       bool   `json:"hidden"`                  // only moderators are shown hidden posts
+	HiddenReason string `json:"hidden_reason,omitempty"` // set with Hidden
+}
+
+// Report is a user's complaint about a post, comment, chat message or user.
+// Excerpt is a snapshot of the reported content taken when it was filed.
+type Report struct {
+	ID                 int64      `json:"id"`
+	ReporterID         string     `json:"reporter_id"`
+	ReporterNickname   string     `json:"reporter_nickname"`
+	TargetType         string     `json:"target_type"`
+	TargetID           string     `json:"target_id"`
+	Excerpt            string     `json:"excerpt"`
+	Category           string     `json:"category"`
+	Details            string     `json:"details"`
+	Status             string     `json:"status"`
+	ClaimedByNickname  string     `json:"claimed_by,omitempty"`
+	ClaimedAt          *time.Time `json:"claimed_at,omitempty"`
+	ResolvedByNickname string     `json:"resolved_by,omitempty"`
+	ResolvedAt         *time.Time `json:"resolved_at,omitempty"`
+	ResolutionNote     string     `json:"resolution_note,omitempty"`
+	ModerationLogID    *int64     `json:"moderation_log_id,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+}
+
+// ModerationLogEntry records one moderator action
+type ModerationLogEntry struct {
+	ID                int64     `json:"id"`
+	ModeratorID       string    `json:"moderator_id"`
+	ModeratorNickname string    `json:"moderator_nickname"`
+	Action            string    `json:"action"`
+	TargetType        string    `json:"target_type"`
+	TargetID          string    `json:"target_id"`
+	Reason            string    `json:"reason"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+type Category struct {
+	Slug        string `json:"slug"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	SortOrder   int    `json:"sort_order"`
+	Archived    bool   `json:"archived"`
+	PostCount   int    `json:"post_count"`
+}
+
+type SearchResult struct {
+	Type       string    `json:"type"` // "post" or "comment"
+	PostID     string    `json:"post_id"`
+	CommentID  string    `json:"comment_id,omitempty"`
+	Title      string    `json:"title"`   // post title, with matches highlighted for posts
+	Snippet    string    `json:"snippet"` // HTML-escaped excerpt with matches wrapped in <mark>
+	CategoryID string    `json:"category_id"`
+	UserID     string    `json:"user_id"`
+	Nickname   string    `json:"nickname"`
+	CreatedAt  time.Time `json:"created_at"`
+	Rank       float64   `json:"rank"` // bm25 score, lower is better
 }
 
 type Comment struct {
-	ID        string    `json:"id"`
-	PostID    string    `json:"post_id"`
-	UserID    string    `json:"user_id"`
-	Nickname  string    `json:"nickname"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
+	ID        string     `json:"id"`
+	PostID    string     `json:"post_id"`
+	UserID    string     `json:"user_id"`
+	Nickname  string     `json:"nickname"`
+	Content   string     `json:"content"`
+	CreatedAt time.Time  `json:"created_at"`
+	ParentID  *string    `json:"parent_id"`
+	Depth     int        `json:"depth"`
+	EditedAt  *time.Time `json:"edited_at"`
+	Deleted   bool       `json:"deleted"` // tombstone kept so its replies stay threaded
 }
 
 type Session struct {
-	UserID    string
-	Nickname  string
-	ExpiresAt time.Time
+	ID            string
+	UserID        string
+	Nickname      string
+	ExpiresAt     time.Time
+	EmailVerified bool
+	Role          string
+}
+
+// SessionInfo describes one of a user's sessions without exposing its cookie value
+type SessionInfo struct {
+	ID         string    `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastActive time.Time `json:"last_active"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	Current    bool      `json:"current"`
 }
 
 type OnlineUser struct {
@@ -53,10 +137,22 @@ type Message struct {
 	Time       string `json:"time"`
 }
 
+type Conversation struct {
+	ChatID          int    `json:"chat_id"`
+	UserID          string `json:"user_id"`
+	Nickname        string `json:"nickname"`
+	LastMessage     string `json:"last_message"`
+	LastMessageID   int    `json:"last_message_id"`
+	LastSenderID    string `json:"last_sender_id"`
+	LastMessageTime string `json:"last_message_time"`
+	UnreadCount     int    `json:"unread_count"`
+}
+
 type WebSocketMessage struct {
 	Type       string `json:"type"`
 	ChatID     int    `json:"chatId"`
 	ReceiverID string `json:"receiverId"`
 	SenderName string `json:"senderName"`
 	Message    string `json:"message"`
+	MessageID  int    `json:"messageId"` // Used by "read" to report the newest message seen
 }
@@ -2,6 +2,7 @@ import { Router } from "./router.js";
 import { setupPostsPage, setupPostDetailsPage } from "./posts.js";
 import { setupSignupForm } from "./signup.js";
 import { setupLoginForm } from "./login.js";
+import { setupForgotPasswordForm, setupResetPasswordForm } from "./password.js";
 import {
   initChatFeatures,
   loadUsers,
@@ -30,6 +31,15 @@ document.addEventListener("DOMContentLoaded", () => {
     setupLoginForm(router, updateNavigation);
   });
 
+  router.addRoute("forgot-password", "forgotPasswordTemplate", () => {
+    setupForgotPasswordForm();
+  });
+
+  // Landing page for the link in password reset emails
+  router.addRoute("reset-password/:token", "resetPasswordTemplate", (params) => {
+    setupResetPasswordForm(router, params.token);
+  });
+
   // Posts route
   router.addRoute("posts", "postsTemplate", () => {
     // Check authentication first
@@ -1,4 +1,5 @@
 import { cleanupChat } from "./chat.js";
+import { csrfHeaders } from "./csrf.js";
 
 // Authentication check function
 export async function isAuthenticated() {
@@ -20,6 +21,7 @@ export async function isAuthenticated() {
 export function logout() {
   return fetch("/api/logout", {
     method: "POST",
+    headers: csrfHeaders(),
     credentials: "include",
   });
 }
@@ -10,6 +10,7 @@ let earliestMessageId = null;
 let chatMessages = [];
 let loadingMessages = false;
 let highlightedUserIds = new Set();
+let onlineUsers = new Map();
 
 // --- Conversation order state ---
 let conversationOrder = JSON.parse(
@@ -54,64 +55,44 @@ function getCurrentUserId() {
     });
 }
 
-// Load users with conversation ordering
+// Render the online users pushed over the WebSocket, in conversation order
 export function loadUsers() {
-  console.log("Loading users with conversation ordering...");
-  fetch("/api/online-users", { credentials: "include" })
-    .then((res) => {
-      console.log("Response status:", res.status);
-      if (!res.ok) {
-        throw new Error(`HTTP error! status: ${res.status}`);
-      }
-      return res.json();
-    })
-    .then((users) => {
-      console.log("Received users:", users);
-      const globalList = document.getElementById("global-user-list");
-      const chatList = document.getElementById("user-list");
-      const list = globalList || chatList;
-      if (!list) {
-        console.error("No user-list element found");
-        return;
-      }
-      list.innerHTML = "";
-      if (!Array.isArray(users) || users.length === 0) {
-        list.innerHTML = "<li>No other users online</li>";
-        return;
-      }
+  const globalList = document.getElementById("global-user-list");
+  const chatList = document.getElementById("user-list");
+  const list = globalList || chatList;
+  if (!list) {
+    return;
+  }
+  list.innerHTML = "";
+  if (onlineUsers.size === 0) {
+    list.innerHTML = "<li>No other users online</li>";
+    return;
+  }
 
-      // Sort users by activity
-      const sortedUsers = sortUsersByActivity(users);
+  const users = Array.from(onlineUsers, ([id, nickname]) => ({ id, nickname }));
 
-      sortedUsers.forEach((user) => {
-        const btn = document.createElement("button");
-        btn.textContent = user.nickname;
-        btn.setAttribute("data-user-id", user.id);
-        btn.className = "user-btn";
+  // Sort users by activity
+  const sortedUsers = sortUsersByActivity(users);
 
-        // Restore highlight if it was previously highlighted
-        if (highlightedUserIds.has(user.id.toString())) {
-          btn.classList.add("new-message");
-        }
+  sortedUsers.forEach((user) => {
+    const btn = document.createElement("button");
+    btn.textContent = user.nickname;
+    btn.setAttribute("data-user-id", user.id);
+    btn.className = "user-btn";
 
-        const li = document.createElement("li");
-        li.appendChild(btn);
-        list.appendChild(li);
+    // Restore highlight if it was previously highlighted
+    if (highlightedUserIds.has(user.id.toString())) {
+      btn.classList.add("new-message");
+    }
 
-        btn.addEventListener("click", () => {
-          handleUserClick(user.id, user.nickname);
-        });
-      });
-    })
-    .catch((error) => {
-      console.error("Error loading users:", error);
-      const globalList = document.getElementById("global-user-list");
-      const chatList = document.getElementById("user-list");
-      const list = globalList || chatList;
-      if (list) {
-        list.innerHTML = "<li>Error loading users</li>";
-      }
+    const li = document.createElement("li");
+    li.appendChild(btn);
+    list.appendChild(li);
+
+    btn.addEventListener("click", () => {
+      handleUserClick(user.id, user.nickname);
     });
+  });
 }
 
 // Handle user click from user list
@@ -238,7 +219,7 @@ function initChatWebSocket() {
           highlightUser(data.sender_id);
         }
 
-        // Always refresh user list to show new order
+        // Re-render the user list to show the new order
         loadUsers();
         hideTypingIndicator();
       } else if (data.type === "typing") {
@@ -253,8 +234,19 @@ function initChatWebSocket() {
           console.log("✅ Hiding typing indicator");
           hideTypingIndicator();
         }
-      } else if (data.type === "presence_update") {
-        // Reload users when someone comes online/offline
+      } else if (data.type === "presence_snapshot") {
+        // The snapshot sent on connect replaces whatever we had
+        onlineUsers = new Map(
+          (data.users || []).map((user) => [user.id, user.nickname])
+        );
+        loadUsers();
+      } else if (data.type === "user_online") {
+        if (data.userId !== currentUserId) {
+          onlineUsers.set(data.userId, data.nickname);
+          loadUsers();
+        }
+      } else if (data.type === "user_offline") {
+        onlineUsers.delete(data.userId);
         loadUsers();
       }
     } catch (error) {
@@ -772,7 +764,7 @@ export function sendMessage() {
   // Update conversation order immediately after sending
   updateConversationOrder(currentReceiverId, new Date().toISOString(), true);
 
-  // Refresh user list to show updated order
+  // Re-render the user list to show the updated order
   loadUsers();
 }
 
@@ -794,6 +786,7 @@ export function cleanupChat() {
   chatMessages = [];
   loadingMessages = false;
   highlightedUserIds.clear();
+  onlineUsers.clear();
 }
 
 // Handle chat socket reconnection
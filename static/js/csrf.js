@@ -0,0 +1,7 @@
+// The server sets a csrf_token cookie that must be echoed on every
+// state-changing request. Merges that header into the given headers.
+export function csrfHeaders(headers = {}) {
+  const match = document.cookie.match(/(?:^|;\s*)csrf_token=([^;]+)/);
+  if (!match) return headers;
+  return { ...headers, "X-CSRF-Token": decodeURIComponent(match[1]) };
+}
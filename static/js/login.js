@@ -1,3 +1,5 @@
+import { csrfHeaders } from "./csrf.js";
+
 // Setup login form functionality
 export function setupLoginForm(router, updateNavigation) {
   console.log("Setting up login form");
@@ -80,20 +82,48 @@ export function setupLoginForm(router, updateNavigation) {
       formData.append("nickname", nickname);
       formData.append("email", email);
       formData.append("password", password);
+      if (form.querySelector("#remember")?.checked) {
+        formData.append("remember", "1");
+      }
 
       console.log("Sending data to server:", formData.toString());
 
       const response = await fetch("/login", {
         method: "POST",
-        headers: {
+        headers: csrfHeaders({
           "Content-Type": "application/x-www-form-urlencoded",
-        },
+        }),
         body: formData.toString(),
       });
 
-      const result = await response.text();
+      let result = await response.text();
       console.log("Server response:", result);
 
+      // Accounts with two-factor authentication need a code before a session is issued
+      if (response.status === 202) {
+        const { pending_token } = JSON.parse(result);
+        const code = window.prompt(
+          "Enter the code from your authenticator app or a recovery code"
+        );
+        if (!code) {
+          showMessage("Login cancelled", true);
+          return;
+        }
+
+        const secondStep = await fetch("/login/2fa", {
+          method: "POST",
+          headers: csrfHeaders({
+            "Content-Type": "application/x-www-form-urlencoded",
+          }),
+          body: new URLSearchParams({ pending_token, code }).toString(),
+        });
+        result = await secondStep.text();
+        if (!secondStep.ok) {
+          showMessage(result || "Login failed", true);
+          return;
+        }
+      }
+
       if (response.ok) {
         showMessage("Login successful! Redirecting...", false);
         // Important: Update navigation BEFORE redirecting
@@ -0,0 +1,119 @@
+import { csrfHeaders } from "./csrf.js";
+
+// Setup the form that requests a password reset link by email
+export function setupForgotPasswordForm() {
+  const form = document.querySelector("#forgotPasswordForm");
+
+  if (!form) {
+    console.error("Forgot password form not found");
+    return;
+  }
+
+  form.addEventListener("submit", async (e) => {
+    e.preventDefault();
+
+    const email = form.querySelector("#email")?.value.trim();
+    if (!email) {
+      showMessage(form, "Email is required", true);
+      return;
+    }
+
+    try {
+      const response = await fetch("/api/password/forgot", {
+        method: "POST",
+        headers: csrfHeaders({
+          "Content-Type": "application/x-www-form-urlencoded",
+        }),
+        body: new URLSearchParams({ email }).toString(),
+      });
+
+      const result = await response.text();
+      showMessage(
+        form,
+        result || (response.ok ? "Check your email" : "Could not send the link"),
+        !response.ok
+      );
+    } catch (error) {
+      console.error("Error requesting password reset:", error);
+      showMessage(form, "An error occurred. Please try again.", true);
+    }
+  });
+}
+
+// Setup the form behind the password reset link; token comes from the URL
+export function setupResetPasswordForm(router, token) {
+  const form = document.querySelector("#resetPasswordForm");
+
+  if (!form) {
+    console.error("Reset password form not found");
+    return;
+  }
+
+  form.addEventListener("submit", async (e) => {
+    e.preventDefault();
+
+    const password = form.querySelector("#password")?.value;
+    const confirmPassword = form.querySelector("#confirmPassword")?.value;
+
+    if (password !== confirmPassword) {
+      showMessage(form, "Passwords do not match", true);
+      return;
+    }
+
+    try {
+      const response = await fetch("/api/password/reset", {
+        method: "POST",
+        headers: csrfHeaders({
+          "Content-Type": "application/x-www-form-urlencoded",
+        }),
+        body: new URLSearchParams({
+          token: decodeURIComponent(token),
+          password,
+          confirmPassword,
+        }).toString(),
+      });
+
+      const result = await response.text();
+      if (response.ok) {
+        showMessage(form, "Password updated! Redirecting to login...", false);
+        setTimeout(() => {
+          router.navigateTo("login");
+        }, 1500);
+      } else {
+        showMessage(form, result || "Could not reset password", true);
+      }
+    } catch (error) {
+      console.error("Error resetting password:", error);
+      showMessage(form, "An error occurred. Please try again.", true);
+    }
+  });
+}
+
+// Message display function for the password forms
+function showMessage(form, message, isError = true) {
+  const existingMsg = document.querySelector(".message");
+  if (existingMsg) existingMsg.remove();
+
+  const msgElement = document.createElement("div");
+  msgElement.className = `message ${isError ? "error" : "success"}`;
+  msgElement.textContent = message;
+
+  msgElement.style.padding = "10px";
+  msgElement.style.margin = "10px 0";
+  msgElement.style.borderRadius = "5px";
+
+  if (isError) {
+    msgElement.style.backgroundColor = "#ffdddd";
+    msgElement.style.color = "#ff0000";
+    msgElement.style.border = "1px solid #ff0000";
+  } else {
+    msgElement.style.backgroundColor = "#ddffdd";
+    msgElement.style.color = "#008800";
+    msgElement.style.border = "1px solid #008800";
+  }
+
+  const submitButton = form.querySelector('button[type="submit"]');
+  if (submitButton) {
+    submitButton.insertAdjacentElement("beforebegin", msgElement);
+  }
+}
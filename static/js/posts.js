@@ -1,3 +1,11 @@
+import { csrfHeaders } from "./csrf.js";
+
+// Feed paging state: the filter the current list was loaded with and the
+// cursor for its next page ("" once the last page is shown)
+let currentCategory = "";
+let currentSort = "";
+let nextCursor = "";
+
 // Escape HTML to prevent XSS
 function escapeHTML(str) {
   if (!str) return "";
@@ -29,6 +37,31 @@ function showPostsError(message) {
   }
 }
 
+// Build the feed URL for a category, sort and page cursor
+function postsURL(category, sort, cursor) {
+  const params = new URLSearchParams();
+  if (category && category !== "all") {
+    params.set("category", category);
+  }
+  if (sort) {
+    params.set("sort", sort);
+  }
+  if (cursor) {
+    params.set("cursor", cursor);
+  }
+  const query = params.toString();
+  return query ? `/api/posts?${query}` : "/api/posts";
+}
+
+// Show the "Load more" button only while there is another page
+function updateLoadMore() {
+  const loadMoreBtn = document.getElementById("load-more-posts");
+  if (loadMoreBtn) {
+    loadMoreBtn.style.display = nextCursor ? "" : "none";
+    loadMoreBtn.disabled = false;
+  }
+}
+
 // Fetch and display posts
 async function loadPosts(category = "") {
   console.log("=== LOAD POSTS CALLED ===");
@@ -40,22 +73,24 @@ async function loadPosts(category = "") {
     return;
   }
 
+  currentCategory = category;
+  nextCursor = "";
+  updateLoadMore();
   showPostsLoading();
 
   try {
-    let url = "/api/posts";
-    if (category && category !== "all") {
-      url += `?category=${encodeURIComponent(category)}`;
-    }
+    const url = postsURL(currentCategory, currentSort, "");
 
     const response = await fetch(url, {
       credentials: "include",
     });
 
     if (response.ok) {
-      const posts = await response.json();
-      console.log("Received posts:", posts);
-      renderPosts(posts);
+      const data = await response.json();
+      console.log("Received posts:", data);
+      renderPosts(data.posts);
+      nextCursor = data.next_cursor || "";
+      updateLoadMore();
     } else if (response.status === 401) {
       console.log("Unauthorized - redirecting to login");
       showPostsError("You need to be logged in to view posts.");
@@ -71,8 +106,43 @@ async function loadPosts(category = "") {
   }
 }
 
-//Render posts with proper clickable titles that work with router
-function renderPosts(posts) {
+// Fetch the next page of the current feed and append it
+async function loadMorePosts() {
+  const cursor = nextCursor;
+  if (!cursor) {
+    return;
+  }
+  const loadMoreBtn = document.getElementById("load-more-posts");
+  if (loadMoreBtn) {
+    loadMoreBtn.disabled = true;
+  }
+
+  try {
+    const response = await fetch(
+      postsURL(currentCategory, currentSort, cursor),
+      { credentials: "include" }
+    );
+    if (!response.ok) {
+      console.error("Failed to load more posts:", await response.text());
+      updateLoadMore();
+      return;
+    }
+    const data = await response.json();
+    if (cursor !== nextCursor) {
+      // The feed was reloaded with another filter while this page loaded
+      return;
+    }
+    renderPosts(data.posts, true);
+    nextCursor = data.next_cursor || "";
+  } catch (err) {
+    console.error("Network error loading more posts:", err);
+  }
+  updateLoadMore();
+}
+
+//Render posts with proper clickable titles that work with router.
+//With append set the posts are added below the ones already shown.
+function renderPosts(posts, append = false) {
   const postsContainer = document.getElementById("posts-container");
 
   if (!postsContainer) {
@@ -81,12 +151,14 @@ function renderPosts(posts) {
   }
 
   if (!posts || posts.length === 0) {
-    postsContainer.innerHTML =
-      "<p>No posts found. Be the first to post something!</p>";
+    if (!append) {
+      postsContainer.innerHTML =
+        "<p>No posts found. Be the first to post something!</p>";
+    }
     return;
   }
 
-  postsContainer.innerHTML = posts
+  const html = posts
     .map(
       (post) => `
     <div class="post-item" style="border: 1px solid #ddd; padding: 15px; margin: 10px 0; border-radius: 5px;">
@@ -113,13 +185,20 @@ function renderPosts(posts) {
       }
       </div>
       <div class="post-footer" style="color: #999; font-size: 0.8em; margin-top: 10px;">
-        Posted: ${new Date(post.created_at).toLocaleString()}
+        Posted by ${escapeHTML(post.author_nickname || "unknown")}: ${new Date(post.created_at).toLocaleString()}
+        · 💬 ${post.comment_count || 0}
       </div>
     </div>
   `
     )
     .join("");
 
+  if (append) {
+    postsContainer.insertAdjacentHTML("beforeend", html);
+  } else {
+    postsContainer.innerHTML = html;
+  }
+
   console.log("Posts rendered with clickable titles");
 }
 
@@ -155,9 +234,9 @@ async function createPost() {
 
     const response = await fetch("/api/posts", {
       method: "POST",
-      headers: {
+      headers: csrfHeaders({
         "Content-Type": "application/json",
-      },
+      }),
       credentials: "include",
       body: JSON.stringify({
         title: title,
@@ -173,10 +252,10 @@ async function createPost() {
       // Clear form
       titleInput.value = "";
       contentInput.value = "";
-      categorySelect.value = "general";
+      categorySelect.selectedIndex = 0;
 
       // Reload posts to show the new one
-      await loadPosts();
+      await loadPosts(currentCategory);
 
       // Show success message
       showSuccessMessage("Post created successfully!");
@@ -210,6 +289,44 @@ function showSuccessMessage(message) {
   }
 }
 
+// Fill the sidebar filter and the post form's selector from /api/categories,
+// so categories created or merged by admins show up without a deploy
+async function loadCategories() {
+  try {
+    const response = await fetch("/api/categories", { credentials: "include" });
+    if (!response.ok) {
+      console.error("Failed to load categories:", await response.text());
+      return;
+    }
+    const categories = await response.json();
+
+    const categoryList = document.getElementById("category-list");
+    if (categoryList) {
+      const active = currentCategory || "all";
+      categoryList.innerHTML = [{ slug: "all", name: "All Posts" }, ...categories]
+        .map(
+          (c) =>
+            `<li data-category="${escapeHTML(c.slug)}"${
+              c.slug === active ? ' class="active"' : ""
+            }>${escapeHTML(c.name)}</li>`
+        )
+        .join("");
+    }
+
+    const categorySelect = document.getElementById("category-select");
+    if (categorySelect) {
+      categorySelect.innerHTML = categories
+        .map(
+          (c) =>
+            `<option value="${escapeHTML(c.slug)}">${escapeHTML(c.name)}</option>`
+        )
+        .join("");
+    }
+  } catch (err) {
+    console.error("Network error loading categories:", err);
+  }
+}
+
 // Set up category filtering
 function setupCategoryFiltering() {
   const categoryList = document.getElementById("category-list");
@@ -222,6 +339,7 @@ function setupCategoryFiltering() {
   // Remove existing event listeners
   const newCategoryList = categoryList.cloneNode(true);
   categoryList.parentNode.replaceChild(newCategoryList, categoryList);
+  loadCategories();
 
   newCategoryList.addEventListener("click", (e) => {
     if (e.target.tagName === "LI" && e.target.hasAttribute("data-category")) {
@@ -247,26 +365,6 @@ function setupCategoryFiltering() {
   });
 }
 
-// Set up online users functionality
-function updateOnlineUsers() {
-  fetch("/api/online-users", { credentials: "include" })
-    .then((response) => response.json())
-    .then((users) => {
-      const list = document.getElementById("onlineUsersList");
-      if (list) {
-        list.innerHTML = users
-          .map(
-            (user) =>
-              `<li class="online-user" data-nickname="${user}">${user}</li>`
-          )
-          .join("");
-      }
-    })
-    .catch((err) => {
-      console.error("Error fetching online users:", err);
-    });
-}
-
 // Main setup function for posts page
 export function setupPostsPage(router) {
   console.log("Setting up posts page with router:", !!router);
@@ -288,9 +386,19 @@ export function setupPostsPage(router) {
       // Set up category filtering
       setupCategoryFiltering();
 
-      // Set up online users updates
-      updateOnlineUsers();
-      setInterval(updateOnlineUsers, 30000);
+      // Set up sorting and feed paging
+      const sortSelect = document.getElementById("sort-select");
+      if (sortSelect) {
+        sortSelect.value = currentSort || "newest";
+        sortSelect.addEventListener("change", () => {
+          currentSort = sortSelect.value;
+          loadPosts(currentCategory);
+        });
+      }
+      const loadMoreBtn = document.getElementById("load-more-posts");
+      if (loadMoreBtn) {
+        loadMoreBtn.addEventListener("click", loadMorePosts);
+      }
 
       // Load initial posts
       loadPosts();
@@ -373,7 +481,8 @@ function renderPostDetails(post) {
   }
         </span>
         <span class="post-date" style="color: #666; font-size: 0.9em;">
-          Posted: ${new Date(post.created_at).toLocaleString()}
+          Posted by ${escapeHTML(post.author_nickname || "unknown")}: ${new Date(post.created_at).toLocaleString()}
+        · 💬 ${post.comment_count || 0}
         </span>
       </div>
     </div>
@@ -448,9 +557,9 @@ async function createComment(postId) {
   try {
     const response = await fetch("/api/comments", {
       method: "POST",
-      headers: {
+      headers: csrfHeaders({
         "Content-Type": "application/json",
-      },
+      }),
       credentials: "include",
       body: JSON.stringify({
         post_id: postId,
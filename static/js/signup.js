@@ -1,3 +1,5 @@
+import { csrfHeaders } from "./csrf.js";
+
 // Setup signup form functionality
 export function setupSignupForm(router) {
   console.log("Setting up signup form");
@@ -27,6 +29,7 @@ export function setupSignupForm(router) {
     try {
       const response = await fetch("/signup", {
         method: "POST",
+        headers: csrfHeaders(),
         body: formData,
       });
 